@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func (server *FNRadioServer) stopUserStreamStations(user string) {
+	for _, streamStation := range server.StreamStations.List() {
+		if streamStation.UserID == user {
+			streamStation.Discard()
+			server.StreamStations.Remove(streamStation)
+		}
+	}
+}
+
+// deleteAccount removes a user and everything tied to them. Ingests of media only they were playing
+// are cancelled unless another ingest or station creation is waiting on them, the media itself is
+// left for the next eviction pass.
+func (server *FNRadioServer) deleteAccount(user string) error {
+	stations, err := server.Store.GetUserStations(user)
+	if err != nil {
+		return err
+	}
+
+	folders := make([]string, 0, len(stations))
+
+	for _, station := range stations {
+		if station.Source.Valid {
+			folders = append(folders, station.Source.String)
+		}
+	}
+
+	for _, streamStation := range server.StreamStations.List() {
+		if streamStation.UserID == user {
+			folders = append(folders, streamStation.Queue.Sources()...)
+		}
+	}
+
+	err = server.Store.DeleteUser(user)
+	if err != nil {
+		return err
+	}
+
+	server.stopUserStreamStations(user)
+	server.Parties.RemoveUser(user)
+
+	references, err := server.mediaReferences()
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range folders {
+		if references[folder] == 0 {
+			if !server.Ingests.Shared(folder) {
+				server.Ingests.Cancel(folder)
+			}
+
+			server.Cache.MarkStale(folder)
+		}
+	}
+
+	return nil
+}
+
+func (server *FNRadioServer) deleteCurrentUser(c *gin.Context) {
+	if !requireBasicAuth(c, "accounts can only be deleted with basic authentication") {
+		return
+	}
+
+	user := c.MustGet("user").(User)
+
+	err := server.deleteAccount(user.ID)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.Status(204)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadAdminToken reads the token guarding the admin API. The admin API is disabled without one.
+func loadAdminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+func (server *FNRadioServer) handleAdminAuth(c *gin.Context) {
+	if server.AdminToken == "" {
+		respondError(c, newAPIError(CodeNotFound, "admin API is disabled"))
+
+		return
+	}
+
+	authorization := strings.Split(c.GetHeader("Authorization"), " ")
+
+	if len(authorization) != 2 || !strings.EqualFold(authorization[0], AuthMethodBearer) ||
+		subtle.ConstantTimeCompare([]byte(authorization[1]), []byte(server.AdminToken)) != 1 {
+		respondError(c, newAPIError(CodeUnauthorized, "invalid admin token"))
+
+		return
+	}
+
+	c.Next()
+}
+
+func (server *FNRadioServer) adminListUsers(c *gin.Context) {
+	users, err := server.Store.ListUsers()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	list := make([]gin.H, 0, len(users))
+
+	for _, user := range users {
+		entry := gin.H{
+			"id": user.ID,
+		}
+
+		if !user.TokensRevokedAt.IsZero() {
+			entry["tokens_revoked_at"] = user.TokensRevokedAt
+		}
+
+		list = append(list, entry)
+	}
+
+	c.JSON(200, list)
+}
+
+func (server *FNRadioServer) adminDeleteUser(c *gin.Context) {
+	err := server.deleteAccount(c.Param("user"))
+	if err != nil {
+		respondError(c, orNotFound(err, errUserNotFound))
+
+		return
+	}
+
+	c.Status(204)
+}
+
+// respondQuota sends a user's quota overrides along with the limits that apply to them.
+func (server *FNRadioServer) respondQuota(c *gin.Context, overrides QuotaOverrides) {
+	c.JSON(200, gin.H{
+		"overrides": overrides,
+		"limits":    overrides.apply(server.DefaultQuota),
+	})
+}
+
+func (server *FNRadioServer) adminGetQuota(c *gin.Context) {
+	_, err := server.Store.GetUser(c.Param("user"))
+	if err != nil {
+		respondError(c, orNotFound(err, errUserNotFound))
+
+		return
+	}
+
+	overrides, err := server.Store.GetQuotaOverrides(c.Param("user"))
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	server.respondQuota(c, overrides)
+}
+
+// adminSetQuota replaces a user's quota overrides. Limits left out or null fall back to the default.
+func (server *FNRadioServer) adminSetQuota(c *gin.Context) {
+	var overrides QuotaOverrides
+
+	err := c.ShouldBindJSON(&overrides)
+	if err != nil {
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	err = overrides.Validate()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	_, err = server.Store.GetUser(c.Param("user"))
+	if err != nil {
+		respondError(c, orNotFound(err, errUserNotFound))
+
+		return
+	}
+
+	err = server.Store.SetQuotaOverrides(c.Param("user"), overrides)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	server.respondQuota(c, overrides)
+}
+
+func (server *FNRadioServer) adminListStations(c *gin.Context) {
+	stations, err := server.Store.ListStations()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	list := make([]gin.H, 0, len(stations))
+
+	for _, station := range stations {
+		entry := gin.H{
+			"user_id": station.UserID,
+			"id":      station.ID,
+			"type":    station.Type,
+		}
+
+		if station.Source.Valid {
+			entry["source"] = station.Source.String
+		}
+
+		list = append(list, entry)
+	}
+
+	c.JSON(200, list)
+}
+
+func (server *FNRadioServer) adminListStreams(c *gin.Context) {
+	streamStations := server.StreamStations.List()
+
+	list := make([]gin.H, 0, len(streamStations))
+
+	for _, streamStation := range streamStations {
+		list = append(list, gin.H{
+			"user_id":      streamStation.UserID,
+			"id":           streamStation.ID,
+			"folder":       streamStation.Folder,
+			"last_request": streamStation.LastRequest,
+			"queue":        streamStation.Queue.Sources(),
+		})
+	}
+
+	c.JSON(200, list)
+}
+
+func (server *FNRadioServer) adminStopStream(c *gin.Context) {
+	streamStation := server.StreamStations.Get(&Station{UserID: c.Param("user"), ID: c.Param("station")})
+	if streamStation == nil {
+		respondError(c, newAPIError(CodeNotFound, "stream not running"))
+
+		return
+	}
+
+	streamStation.Discard()
+	server.StreamStations.Remove(streamStation)
+
+	err := server.Store.SaveStreamQueue(streamStation.UserID, streamStation.ID, SavedStreamQueue{})
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.Status(204)
+}
+
+func (server *FNRadioServer) adminCleanupBrokenStations(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"removed": server.cleanupBrokenStations(),
+	})
+}
+
+// adminCacheReport shows what the next eviction pass would do, as seen by the running server.
+func (server *FNRadioServer) adminCacheReport(c *gin.Context) {
+	report, err := server.planEviction()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.JSON(200, report)
+}
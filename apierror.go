@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an API error. Clients should switch on the
+// code, the message is only meant for humans.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest    ErrorCode = "invalid_request"
+	CodeInvalidSource     ErrorCode = "invalid_source"
+	CodeSourceUnavailable ErrorCode = "source_unavailable"
+	CodeUnauthorized      ErrorCode = "unauthorized"
+	CodeForbidden         ErrorCode = "forbidden"
+	CodeNotFound          ErrorCode = "not_found"
+	CodeConflict          ErrorCode = "conflict"
+	CodePayloadTooLarge   ErrorCode = "payload_too_large"
+	CodeQuotaExceeded     ErrorCode = "quota_exceeded"
+	CodeRateLimited       ErrorCode = "rate_limited"
+	CodeInternal          ErrorCode = "internal_error"
+)
+
+var errorCodeStatus = map[ErrorCode]int{
+	CodeInvalidRequest:    400,
+	CodeInvalidSource:     400,
+	CodeSourceUnavailable: 400,
+	CodeUnauthorized:      401,
+	CodeForbidden:         403,
+	CodeNotFound:          404,
+	CodeConflict:          409,
+	CodePayloadTooLarge:   413,
+	CodeQuotaExceeded:     403,
+	CodeRateLimited:       429,
+	CodeInternal:          500,
+}
+
+// APIError is an error that is safe to show to clients. Err is the underlying cause, it is only
+// logged and never sent.
+type APIError struct {
+	Code    ErrorCode
+	Message string
+	Details []FieldError
+	Err     error
+}
+
+func (err *APIError) Error() string {
+	return err.Message
+}
+
+func (err *APIError) Unwrap() error {
+	return err.Err
+}
+
+func (err *APIError) Status() int {
+	if status, ok := errorCodeStatus[err.Code]; ok {
+		return status
+	}
+
+	return 500
+}
+
+func newAPIError(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+// userErrors are errors whose message is written for clients, along with the code they map to.
+var userErrors = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrNotFound, CodeNotFound},
+	{ErrConflict, CodeConflict},
+	{ErrInvalidSource, CodeInvalidSource},
+	{ErrNoPlaylistItems, CodeSourceUnavailable},
+	{ErrUploadNotFound, CodeSourceUnavailable},
+	{ErrLiveStream, CodeInvalidSource},
+	{ErrNoFormats, CodeSourceUnavailable},
+	{ErrSourceTooLong, CodeInvalidSource},
+	{ErrUnprobeableMedia, CodeInvalidSource},
+	{ErrUnknownDuration, CodeInvalidSource},
+	{ErrSourceTooLarge, CodeInvalidSource},
+	{ErrBlockedAddress, CodeInvalidSource},
+	{ErrStationQuotaExceeded, CodeQuotaExceeded},
+	{ErrBindingQuotaExceeded, CodeQuotaExceeded},
+	{ErrQueueQuotaExceeded, CodeQuotaExceeded},
+	{ErrIngestQuotaExceeded, CodeQuotaExceeded},
+	{ErrIngestCancelled, CodeSourceUnavailable},
+	{ErrQueueElementNotFound, CodeNotFound},
+	{ErrQueuePositionInvalid, CodeInvalidRequest},
+	{ErrPartyExists, CodeConflict},
+	{ErrPartyNotFound, CodeNotFound},
+	{errInvalidToken, CodeUnauthorized},
+	{ErrTokenExpired, CodeUnauthorized},
+	{ErrTokenRevoked, CodeUnauthorized},
+}
+
+// toAPIError turns any error into one that can be sent to clients. Unknown errors become a generic
+// internal error so database and filesystem details don't leak.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for _, userError := range userErrors {
+		if errors.Is(err, userError.err) {
+			return &APIError{Code: userError.code, Message: userError.err.Error(), Err: err}
+		}
+	}
+
+	return &APIError{Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// sourceError is toAPIError for errors coming out of source resolution, where anything unexpected
+// (network failures, upstream changes, ...) means the source couldn't be fetched.
+func sourceError(err error) *APIError {
+	apiErr := toAPIError(err)
+	if apiErr.Code == CodeInternal {
+		return &APIError{Code: CodeSourceUnavailable, Message: "source could not be fetched", Err: err}
+	}
+
+	return apiErr
+}
+
+// respondError aborts the request with err as a JSON error body.
+func respondError(c *gin.Context, err error) {
+	apiErr := toAPIError(err)
+
+	if apiErr.Err != nil && (apiErr.Code == CodeInternal || apiErr.Code == CodeSourceUnavailable) {
+		fmt.Println(c.Request.Method, c.Request.URL.Path, apiErr.Err)
+	}
+
+	body := gin.H{
+		"error": apiErr.Message,
+		"code":  apiErr.Code,
+	}
+
+	if len(apiErr.Details) > 0 {
+		body["details"] = apiErr.Details
+	}
+
+	c.AbortWithStatusJSON(apiErr.Status(), body)
+}
+
+// invalidRequest is the error for request bodies that can't be decoded.
+func invalidRequest(err error) *APIError {
+	return &APIError{Code: CodeInvalidRequest, Message: "invalid request body", Err: err}
+}
+
+var (
+	errUserNotFound    = newAPIError(CodeNotFound, "user not found")
+	errStationNotFound = newAPIError(CodeNotFound, "station not found")
+	errStationExists   = newAPIError(CodeConflict, "station already exists")
+	errBindingNotFound = newAPIError(CodeNotFound, "binding not found")
+)
+
+// orNotFound replaces ErrNotFound with a more specific not found error.
+func orNotFound(err error, notFound *APIError) error {
+	if errors.Is(err, ErrNotFound) {
+		return notFound
+	}
+
+	return err
+}
+
+// orConflict replaces ErrConflict with a more specific conflict error.
+func orConflict(err error, conflict *APIError) error {
+	if errors.Is(err, ErrConflict) {
+		return conflict
+	}
+
+	return err
+}
@@ -5,6 +5,10 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 )
 
 type Playlist struct {
@@ -60,3 +64,80 @@ func encodeBlurlData(data []byte) ([]byte, error) {
 
 	return w.Bytes(), nil
 }
+
+func decodeBlurl(data []byte) (*BLURL, error) {
+	decoded, err := decodeBlurlData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var blurl BLURL
+
+	err = json.Unmarshal(decoded, &blurl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blurl, nil
+}
+
+func decodeBlurlData(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, errors.New("blurl is too short")
+	}
+
+	if string(data[:4]) != "blul" {
+		return nil, errors.New("invalid blurl magic")
+	}
+
+	length := binary.BigEndian.Uint32(data[4:8])
+
+	reader, err := zlib.NewReader(bytes.NewReader(data[8:]))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	inflated, err := io.ReadAll(io.LimitReader(reader, int64(length)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(inflated)) != length {
+		return nil, fmt.Errorf("blurl length mismatch: header says %d bytes, got %d", length, len(inflated))
+	}
+
+	return inflated, nil
+}
+
+func printBlurlFile(path string) error {
+	var (
+		data []byte
+		err  error
+	)
+
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	blurl, err := decodeBlurl(data)
+	if err != nil {
+		return err
+	}
+
+	pretty, err := json.MarshalIndent(blurl, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(pretty))
+
+	return nil
+}
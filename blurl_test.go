@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestBlurlRoundTrip(t *testing.T) {
+	blurl := &BLURL{
+		Playlists: []Playlist{{Type: "main", Language: "en", URL: "https://example.com/master.m3u8", Duration: 120}},
+		AudioOnly: true,
+		PartySync: true,
+		Duration:  120,
+	}
+
+	encoded, err := encodeBlurl(blurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeBlurl(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(blurl, decoded) {
+		t.Errorf("round trip changed the blurl: %+v", decoded)
+	}
+}
+
+func TestDecodeBlurlRejectsBadInput(t *testing.T) {
+	encoded, err := encodeBlurlData([]byte(`{"playlists":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withLength := func(length uint32) []byte {
+		data := append([]byte(nil), encoded...)
+		binary.BigEndian.PutUint32(data[4:8], length)
+
+		return data
+	}
+
+	notJSON, err := encodeBlurlData([]byte("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		"too short":        []byte("blul"),
+		"bad magic":        append([]byte("blob"), encoded[4:]...),
+		"not zlib":         append(append([]byte(nil), encoded[:8]...), "plain text"...),
+		"truncated zlib":   encoded[:len(encoded)-6],
+		"length too small": withLength(4),
+		"length too large": withLength(1 << 31),
+		"not json":         notJSON,
+	}
+
+	for name, data := range cases {
+		if _, err := decodeBlurl(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
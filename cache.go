@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evictionGrace keeps recently resolved folders around long enough for the station using them to be saved.
+const evictionGrace = time.Minute
+
+// MediaCache keeps track of when media folders were last used so unused ones can be evicted. Access
+// times are saved periodically so a restart doesn't make every folder look unused.
+type MediaCache struct {
+	MaxBytes    int64
+	MaxAge      time.Duration
+	access      map[string]time.Time
+	touched     map[string]bool
+	stale       map[string]bool
+	persistence Store
+	mu          sync.Mutex
+}
+
+type CacheEntry struct {
+	Folder     string    `json:"folder"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"last_access"`
+	References int       `json:"references"`
+	Evict      bool      `json:"evict"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+type CacheReport struct {
+	TotalSize   int64        `json:"total_size"`
+	EvictedSize int64        `json:"evicted_size"`
+	Entries     []CacheEntry `json:"entries"`
+}
+
+func mediaCacheMaxBytes() int64 {
+	maxBytes, _ := strconv.ParseInt(os.Getenv("MEDIA_CACHE_MAX_BYTES"), 10, 64)
+
+	return maxBytes
+}
+
+func mediaCacheMaxAge() time.Duration {
+	maxAge, _ := time.ParseDuration(os.Getenv("MEDIA_CACHE_MAX_AGE"))
+
+	return maxAge
+}
+
+func (cache *MediaCache) Enabled() bool {
+	return cache.MaxBytes > 0 || cache.MaxAge > 0
+}
+
+func (cache *MediaCache) Touch(folder string) {
+	if folder == "" {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.access == nil {
+		cache.access = make(map[string]time.Time)
+	}
+
+	if cache.touched == nil {
+		cache.touched = make(map[string]bool)
+	}
+
+	cache.access[folder] = time.Now()
+	cache.touched[folder] = true
+	delete(cache.stale, folder)
+}
+
+// MarkStale flags a folder to be evicted on the next pass once nothing references it anymore,
+// regardless of the cache budget.
+func (cache *MediaCache) MarkStale(folder string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.stale == nil {
+		cache.stale = make(map[string]bool)
+	}
+
+	cache.stale[folder] = true
+}
+
+func (cache *MediaCache) IsStale(folder string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.stale[folder]
+}
+
+func (cache *MediaCache) Forget(folder string) {
+	cache.mu.Lock()
+
+	delete(cache.access, folder)
+	delete(cache.touched, folder)
+	delete(cache.stale, folder)
+
+	cache.mu.Unlock()
+
+	if cache.persistence != nil {
+		_ = cache.persistence.DeleteMediaAccess([]string{folder})
+	}
+}
+
+// Load picks up the access times saved before the last restart.
+func (cache *MediaCache) Load() error {
+	if cache.persistence == nil {
+		return nil
+	}
+
+	access, err := cache.persistence.GetMediaAccess()
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.access == nil {
+		cache.access = make(map[string]time.Time)
+	}
+
+	for folder, accessedAt := range access {
+		if accessedAt.After(cache.access[folder]) {
+			cache.access[folder] = accessedAt
+		}
+	}
+
+	return nil
+}
+
+// Save persists the access times that changed since the last save.
+func (cache *MediaCache) Save() error {
+	if cache.persistence == nil {
+		return nil
+	}
+
+	cache.mu.Lock()
+
+	pending := make(map[string]time.Time, len(cache.touched))
+
+	for folder := range cache.touched {
+		if accessedAt, ok := cache.access[folder]; ok {
+			pending[folder] = accessedAt
+		}
+	}
+
+	cache.touched = nil
+
+	cache.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := cache.persistence.SaveMediaAccess(pending)
+	if err != nil {
+		// Try again on the next save
+		cache.mu.Lock()
+
+		if cache.touched == nil {
+			cache.touched = make(map[string]bool)
+		}
+
+		for folder := range pending {
+			cache.touched[folder] = true
+		}
+
+		cache.mu.Unlock()
+	}
+
+	return err
+}
+
+func (cache *MediaCache) LastAccess(folder string, fallback time.Time) time.Time {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if access, ok := cache.access[folder]; ok {
+		return access
+	}
+
+	return fallback
+}
+
+func folderSize(dir string) int64 {
+	var size int64
+
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // nolint:nilerr
+		}
+
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+
+		return nil
+	})
+
+	return size
+}
+
+// mediaReferences counts how many stations and stream queues are using each media folder.
+func (server *FNRadioServer) mediaReferences() (map[string]int, error) {
+	references, err := server.Store.SourceReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	server.StreamStations.mu.Lock()
+	defer server.StreamStations.mu.Unlock()
+
+	for _, station := range server.StreamStations.Stations {
+		for _, source := range station.Queue.Sources() {
+			references[source]++
+		}
+	}
+
+	return references, nil
+}
+
+// planEviction works out which media folders should be evicted without touching anything.
+func (server *FNRadioServer) planEviction() (*CacheReport, error) { // nolint:funlen
+	references, err := server.mediaReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.ReadDir("media")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CacheReport{
+		Entries: make([]CacheEntry, 0, len(dir)),
+	}
+
+	for _, file := range dir {
+		// Stream stations clean up after themselves
+		if !file.IsDir() || strings.HasPrefix(file.Name(), "STR_") {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entry := CacheEntry{
+			Folder:     file.Name(),
+			Size:       folderSize("media/" + file.Name()),
+			LastAccess: server.Cache.LastAccess(file.Name(), info.ModTime()),
+			References: references[file.Name()],
+		}
+
+		report.TotalSize += entry.Size
+		report.Entries = append(report.Entries, entry)
+	}
+
+	// Least recently used first
+	sort.Slice(report.Entries, func(i, j int) bool {
+		return report.Entries[i].LastAccess.Before(report.Entries[j].LastAccess)
+	})
+
+	remaining := report.TotalSize
+
+	for i := range report.Entries {
+		entry := &report.Entries[i]
+
+		if entry.References > 0 || server.Ingests.InUse(entry.Folder) || time.Since(entry.LastAccess) < evictionGrace {
+			continue
+		}
+
+		switch {
+		case server.Cache.IsStale(entry.Folder):
+			entry.Reason = "no longer used by its owner"
+		case server.Cache.MaxAge > 0 && time.Since(entry.LastAccess) > server.Cache.MaxAge:
+			entry.Reason = "not accessed in " + server.Cache.MaxAge.String()
+		case server.Cache.MaxBytes > 0 && remaining > server.Cache.MaxBytes:
+			entry.Reason = "cache is over its size budget"
+		default:
+			continue
+		}
+
+		entry.Evict = true
+		remaining -= entry.Size
+		report.EvictedSize += entry.Size
+	}
+
+	return report, nil
+}
+
+func (server *FNRadioServer) evictMedia() (*CacheReport, error) {
+	report, err := server.planEviction()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range report.Entries {
+		entry := &report.Entries[i]
+
+		if entry.Evict && !server.evictFolder(entry.Folder, entry.LastAccess) {
+			entry.Evict = false
+			entry.Reason = ""
+			report.EvictedSize -= entry.Size
+		}
+	}
+
+	return report, nil
+}
+
+// evictFolder removes a folder planned for eviction, unless it was used since the plan was made.
+// The folder is claimed in the ingest registry while it's checked and removed so it can't be
+// resolved again halfway through.
+func (server *FNRadioServer) evictFolder(folder string, lastAccess time.Time) bool {
+	if !server.Ingests.ClaimEviction(folder) {
+		return false
+	}
+
+	defer server.Ingests.Finish(folder, nil)
+
+	if !server.Cache.LastAccess(folder, lastAccess).Equal(lastAccess) {
+		return false
+	}
+
+	references, err := server.mediaReferences()
+	if err != nil || references[folder] > 0 {
+		return false
+	}
+
+	_ = os.RemoveAll("media/" + folder)
+	server.Cache.Forget(folder)
+
+	return true
+}
+
+func (server *FNRadioServer) runCacheEviction(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for range ticker.C {
+		err := server.Cache.Save()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		report, err := server.evictMedia()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if server.Debug && report.EvictedSize > 0 {
+			fmt.Printf("evicted %d bytes of media\n", report.EvictedSize)
+		}
+	}
+}
+
+// printCacheReport prints what an eviction pass would do, based on the saved access times. It runs
+// outside the server, so ingests in flight aren't known; GET /admin/cache asks the running server.
+func (server *FNRadioServer) printCacheReport() error {
+	report, err := server.planEviction()
+	if err != nil {
+		return err
+	}
+
+	pretty, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(pretty))
+
+	return nil
+}
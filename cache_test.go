@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testMediaAccess checks that access times saved through a MediaCache come back after a restart.
+func testMediaAccess(t *testing.T, store Store, folder string) {
+	t.Helper()
+
+	cache := &MediaCache{persistence: store}
+	cache.Touch(folder)
+
+	accessedAt := cache.LastAccess(folder, time.Time{})
+
+	err := cache.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Older times don't overwrite newer ones
+	err = store.SaveMediaAccess(map[string]time.Time{folder: accessedAt.Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &MediaCache{persistence: store}
+
+	err = restarted.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Postgres only keeps microseconds
+	if loaded := restarted.LastAccess(folder, time.Time{}); loaded.Sub(accessedAt) < -time.Microsecond || loaded.After(accessedAt) {
+		t.Errorf("expected the access time %v to survive a restart, got %v", accessedAt, loaded)
+	}
+
+	restarted.Forget(folder)
+
+	restarted = &MediaCache{persistence: store}
+
+	err = restarted.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded := restarted.LastAccess(folder, time.Time{}); !loaded.IsZero() {
+		t.Errorf("forgotten folder still has the access time %v", loaded)
+	}
+}
+
+func TestMediaAccessSurvivesRestart(t *testing.T) {
+	testMediaAccess(t, NewMemoryStore(), "YT_aaaaaaaaaaa")
+}
+
+func TestAdminCacheReport(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.adminRequest(t, "GET", "/admin/cache", nil), 200, "")
+	expectStatus(t, server.request(t, nil, "GET", "/admin/cache", nil), 401, CodeUnauthorized)
+}
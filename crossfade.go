@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// CrossfadePreload is how much earlier than the crossfade the next element starts decoding, so it
+// has audio ready by the time the fade begins.
+const CrossfadePreload = 5 * BytesPerSecond
+
+const bytesPerSampleFrame = 4 // 16-bit stereo
+
+// crossfadeDuration reads CROSSFADE_SECONDS. Crossfading is off unless it's set.
+func crossfadeDuration() time.Duration {
+	seconds, err := strconv.ParseFloat(os.Getenv("CROSSFADE_SECONDS"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// crossfadeBytes converts a crossfade duration to a whole number of sample frames worth of PCM.
+func crossfadeBytes(duration time.Duration) int {
+	return int(duration.Seconds()*BytesPerSecond) / bytesPerSampleFrame * bytesPerSampleFrame
+}
+
+func clampSample(sample float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(sample))))
+}
+
+// mixCrossfade fades out of outgoing and into incoming with an equal-power curve, writing the result
+// to outgoing. Both buffers hold interleaved s16le stereo and position is how many bytes into a fade
+// of length bytes the buffers start. Past the end of the fade only incoming is heard.
+func mixCrossfade(outgoing []byte, incoming []byte, position int, length int) {
+	for i := 0; i+bytesPerSampleFrame <= len(outgoing); i += bytesPerSampleFrame {
+		progress := 1.0
+		if length > 0 {
+			progress = math.Min(1, math.Max(0, float64(position+i)/float64(length)))
+		}
+
+		// cos² + sin² = 1 keeps the combined power constant through the fade
+		fadeOut := math.Cos(progress * math.Pi / 2)
+		fadeIn := math.Sin(progress * math.Pi / 2)
+
+		for j := i; j < i+bytesPerSampleFrame; j += 2 {
+			var in int16
+			if j+2 <= len(incoming) {
+				in = int16(binary.LittleEndian.Uint16(incoming[j:]))
+			}
+
+			out := int16(binary.LittleEndian.Uint16(outgoing[j:]))
+			mixed := clampSample(float64(out)*fadeOut + float64(in)*fadeIn)
+
+			binary.LittleEndian.PutUint16(outgoing[j:], uint16(mixed))
+		}
+	}
+}
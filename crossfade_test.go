@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// pcm returns size bytes of s16le audio where every sample is value.
+func pcm(value int16, size int) []byte {
+	buffer := make([]byte, size)
+
+	for i := 0; i+2 <= size; i += 2 {
+		binary.LittleEndian.PutUint16(buffer[i:], uint16(value))
+	}
+
+	return buffer
+}
+
+// sampleAt returns the sample starting at byte offset of buffer.
+func sampleAt(buffer []byte, offset int) int16 {
+	return int16(binary.LittleEndian.Uint16(buffer[offset:]))
+}
+
+func TestMixCrossfadeEqualPower(t *testing.T) {
+	const length = 1000 * bytesPerSampleFrame
+	const value = 10000
+
+	for _, position := range []int{0, length / 8, length / 4, length / 2, length * 3 / 4, length - bytesPerSampleFrame} {
+		// Mixing each side against silence gives its gain on its own
+		outgoing := pcm(value, bytesPerSampleFrame)
+		mixCrossfade(outgoing, pcm(0, bytesPerSampleFrame), position, length)
+
+		incoming := pcm(0, bytesPerSampleFrame)
+		mixCrossfade(incoming, pcm(value, bytesPerSampleFrame), position, length)
+
+		fadeOut := float64(sampleAt(outgoing, 0)) / value
+		fadeIn := float64(sampleAt(incoming, 0)) / value
+
+		if power := fadeOut*fadeOut + fadeIn*fadeIn; math.Abs(power-1) > 0.001 {
+			t.Errorf("position %d: gains %f and %f don't add up to constant power (%f)", position, fadeOut, fadeIn, power)
+		}
+
+		if sampleAt(outgoing, 0) != sampleAt(outgoing, 2) {
+			t.Errorf("position %d: channels of a frame were faded differently", position)
+		}
+	}
+
+	// The curve starts on the outgoing side and gets closer to the incoming one as it goes
+	buffer := pcm(value, length)
+	mixCrossfade(buffer, pcm(0, length), 0, length)
+
+	if sampleAt(buffer, 0) != value {
+		t.Errorf("fade doesn't start at full volume: %d", sampleAt(buffer, 0))
+	}
+
+	for i := bytesPerSampleFrame; i < length; i += bytesPerSampleFrame {
+		if sampleAt(buffer, i) > sampleAt(buffer, i-bytesPerSampleFrame) {
+			t.Fatalf("outgoing got louder at byte %d", i)
+		}
+	}
+}
+
+func TestMixCrossfadeClips(t *testing.T) {
+	const length = 100 * bytesPerSampleFrame
+
+	loud := pcm(math.MaxInt16, bytesPerSampleFrame)
+	mixCrossfade(loud, pcm(math.MaxInt16, bytesPerSampleFrame), length/2, length)
+
+	if sampleAt(loud, 0) != math.MaxInt16 {
+		t.Errorf("expected clipping at %d, got %d", math.MaxInt16, sampleAt(loud, 0))
+	}
+
+	quiet := pcm(math.MinInt16, bytesPerSampleFrame)
+	mixCrossfade(quiet, pcm(math.MinInt16, bytesPerSampleFrame), length/2, length)
+
+	if sampleAt(quiet, 0) != math.MinInt16 {
+		t.Errorf("expected clipping at %d, got %d", math.MinInt16, sampleAt(quiet, 0))
+	}
+}
+
+func TestMixCrossfadeShortIncoming(t *testing.T) {
+	const length = 100 * bytesPerSampleFrame
+
+	// One whole frame and half a sample of incoming audio, the rest counts as silence
+	incoming := pcm(1000, bytesPerSampleFrame+1)
+	outgoing := pcm(1000, 3*bytesPerSampleFrame)
+
+	mixCrossfade(outgoing, incoming, length, length)
+
+	if sampleAt(outgoing, 0) != 1000 || sampleAt(outgoing, 2) != 1000 {
+		t.Errorf("first frame should be the incoming audio, got %d %d", sampleAt(outgoing, 0), sampleAt(outgoing, 2))
+	}
+
+	for i := bytesPerSampleFrame; i < len(outgoing); i += 2 {
+		if sampleAt(outgoing, i) != 0 {
+			t.Errorf("byte %d should be silent past the end of incoming, got %d", i, sampleAt(outgoing, i))
+		}
+	}
+}
+
+func TestMixCrossfadePastLength(t *testing.T) {
+	const length = 100 * bytesPerSampleFrame
+
+	for _, position := range []int{length, length * 2} {
+		outgoing := pcm(5000, 10*bytesPerSampleFrame)
+		mixCrossfade(outgoing, pcm(-3000, 10*bytesPerSampleFrame), position, length)
+
+		for i := 0; i < len(outgoing); i += 2 {
+			if sampleAt(outgoing, i) != -3000 {
+				t.Fatalf("position %d: only incoming should be heard past the fade, got %d at byte %d", position, sampleAt(outgoing, i), i)
+			}
+		}
+	}
+
+	// A fade without length is an instant cut
+	outgoing := pcm(5000, bytesPerSampleFrame)
+	mixCrossfade(outgoing, pcm(-3000, bytesPerSampleFrame), 0, 0)
+
+	if sampleAt(outgoing, 0) != -3000 {
+		t.Errorf("zero length fade should cut to incoming, got %d", sampleAt(outgoing, 0))
+	}
+}
+
+func TestCrossfadeBytes(t *testing.T) {
+	if got := crossfadeBytes(TickLengthInSeconds * time.Second); got != BytesPerTick {
+		t.Errorf("expected %d bytes for a tick, got %d", BytesPerTick, got)
+	}
+
+	if got := crossfadeBytes(time.Millisecond); got%bytesPerSampleFrame != 0 {
+		t.Errorf("%d bytes isn't a whole number of sample frames", got)
+	}
+}
+
+// bufferedElement is a queue element that already decoded all of its audio.
+func bufferedElement(value int16, size int) *StreamQueueElement {
+	el := newStreamQueueElement("user", "YT_test", nil)
+	el.data = pcm(value, size)
+	el.started = true
+	el.done = true
+
+	return el
+}
+
+func TestGetAudioFrameCrossfades(t *testing.T) {
+	const (
+		outValue = 8000
+		inValue  = -4000
+	)
+
+	outgoing := bufferedElement(outValue, BytesPerTick*5/2)
+	incoming := bufferedElement(inValue, BytesPerTick*3)
+
+	queue := StreamQueue{elements: []*StreamQueueElement{outgoing, incoming}}
+	queue.SetCrossfade(2 * TickLengthInSeconds * time.Second)
+
+	// 2.5 ticks are left, more than the 2 tick crossfade
+	frame, _ := queue.GetAudioFrame()
+
+	if queue.fadeFrom != nil || sampleAt(frame, BytesPerTick-2) != outValue {
+		t.Fatal("fade started too early")
+	}
+
+	// 1.5 ticks are left, so the fade starts and lasts for exactly those
+	frame, _ = queue.GetAudioFrame()
+
+	if queue.fadeFrom != outgoing || queue.fadeTo != incoming {
+		t.Fatal("fade didn't start")
+	}
+
+	if queue.fadeLength != BytesPerTick*3/2 {
+		t.Errorf("expected a fade of %d bytes, got %d", BytesPerTick*3/2, queue.fadeLength)
+	}
+
+	if sampleAt(frame, 0) != outValue {
+		t.Errorf("fade should start at the outgoing element, got %d", sampleAt(frame, 0))
+	}
+
+	// The last tick picks the curve up where the previous one left off
+	frame, _ = queue.GetAudioFrame()
+
+	progress := 2.0 / 3
+	expected := clampSample(outValue*math.Cos(progress*math.Pi/2) + inValue*math.Sin(progress*math.Pi/2))
+
+	if got := sampleAt(frame, 0); math.Abs(float64(got-expected)) > 1 {
+		t.Errorf("expected %d a third of the way from the end of the fade, got %d", expected, got)
+	}
+
+	if got := sampleAt(frame, BytesPerTick-2); got != inValue {
+		t.Errorf("expected only the incoming element after the fade, got %d", got)
+	}
+
+	// The outgoing element is done, and the incoming one played 2 ticks during the fade
+	if queue.Len() != 1 || queue.elements[0] != incoming {
+		t.Fatal("queue didn't advance to the incoming element")
+	}
+
+	if remaining, _ := incoming.Buffered(); remaining != BytesPerTick {
+		t.Errorf("expected a tick of the incoming element left, got %d bytes", remaining)
+	}
+
+	// A new pair of elements means the old fade is forgotten
+	queue.Add(bufferedElement(0, BytesPerTick))
+	_, _ = queue.GetAudioFrame()
+
+	if queue.fadeFrom == outgoing {
+		t.Error("fade outlived the outgoing element")
+	}
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"os"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type User struct {
-	ID     string
-	Secret string
+	ID              string
+	Secret          string
+	TokensRevokedAt time.Time
 }
 
 type Binding struct {
@@ -21,35 +26,171 @@ type Binding struct {
 }
 
 type Station struct {
-	UserID string         `json:"user_id,omitempty"`
-	ID     string         `json:"id"`
-	Type   string         `json:"type"`
-	Source sql.NullString `json:"-"`
+	UserID  string         `json:"user_id,omitempty"`
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Source  sql.NullString `json:"-"`
+	Repeat  string         `json:"repeat,omitempty"`
+	Shuffle bool           `json:"shuffle,omitempty"`
 }
 
-func (server *FNRadioServer) setupDB() {
-	var err error
+// PostgresStore is the Store used in production.
+type PostgresStore struct {
+	DB *pgxpool.Pool
+}
 
-	server.DB, err = pgxpool.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+func connectPostgres() *PostgresStore {
+	db, err := pgxpool.Connect(context.Background(), os.Getenv("DATABASE_URL"))
 	if err != nil {
 		panic(err)
 	}
 
-	file, err := os.ReadFile("init.sql")
+	return &PostgresStore{DB: db}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// pgUniqueViolation is the SQLSTATE of inserts clashing with a primary key or unique constraint.
+const pgUniqueViolation = "23505"
+
+func conflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return ErrConflict
+	}
+
+	return err
+}
+
+// updated turns an update that didn't match any row into ErrNotFound.
+func updated(tag pgconn.CommandTag, err error) error {
 	if err != nil {
-		return
+		return err
 	}
 
-	_, err = server.DB.Exec(context.Background(), string(file))
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (store *PostgresStore) CreateUser(user User) error {
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO users (id, secret) VALUES ($1, $2)", user.ID, user.Secret)
+
+	return conflict(err)
+}
+
+func (store *PostgresStore) GetUser(id string) (*User, error) {
+	var revokedAt *time.Time
+
+	user := User{}
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT id, secret, tokens_revoked_at FROM users WHERE id = $1", id).Scan(&user.ID, &user.Secret, &revokedAt)
 	if err != nil {
-		panic(err)
+		return nil, notFound(err)
+	}
+
+	if revokedAt != nil {
+		user.TokensRevokedAt = *revokedAt
+	}
+
+	return &user, nil
+}
+
+func (store *PostgresStore) SetUserSecret(id string, secret string) error {
+	return updated(store.DB.Exec(context.TODO(), "UPDATE users SET secret = $1 WHERE id = $2", secret, id))
+}
+
+func (store *PostgresStore) RevokeUserTokens(id string, at time.Time) error {
+	return updated(store.DB.Exec(context.TODO(), "UPDATE users SET tokens_revoked_at = $1 WHERE id = $2", at, id))
+}
+
+func (store *PostgresStore) ListUsers() ([]User, error) {
+	users := make([]User, 0)
+
+	rows, err := store.DB.Query(context.TODO(), "SELECT id, secret, tokens_revoked_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var revokedAt *time.Time
+
+		user := User{}
+
+		err = rows.Scan(&user.ID, &user.Secret, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if revokedAt != nil {
+			user.TokensRevokedAt = *revokedAt
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (store *PostgresStore) DeleteUser(id string) error {
+	return store.DB.BeginFunc(context.TODO(), func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.TODO(), "DELETE FROM bindings WHERE user_id = $1 OR station_user = $1", id)
+		if err != nil {
+			return err
+		}
+
+		// Stations, quotas and ingest usage cascade
+		tag, err := tx.Exec(context.TODO(), "DELETE FROM users WHERE id = $1", id)
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+func (store *PostgresStore) ListStations() ([]Station, error) {
+	stations := make([]Station, 0)
+
+	rows, err := store.DB.Query(context.TODO(), "SELECT user_id, id, type, source, repeat, shuffle FROM stations ORDER BY user_id, id")
+	if err != nil {
+		return nil, err
 	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		station := Station{}
+
+		err = rows.Scan(&station.UserID, &station.ID, &station.Type, &station.Source, &station.Repeat, &station.Shuffle)
+		if err != nil {
+			return nil, err
+		}
+
+		stations = append(stations, station)
+	}
+
+	return stations, rows.Err()
 }
 
-func (server *FNRadioServer) getUserStations(user string) ([]Station, error) {
+func (store *PostgresStore) GetUserStations(user string) ([]Station, error) {
 	var stations []Station
 
-	rows, err := server.DB.Query(context.TODO(), "SELECT id, type, source FROM stations WHERE user_id = $1", user)
+	rows, err := store.DB.Query(context.TODO(), "SELECT id, type, source, repeat, shuffle FROM stations WHERE user_id = $1", user)
 	if err != nil {
 		return nil, err
 	}
@@ -57,9 +198,9 @@ func (server *FNRadioServer) getUserStations(user string) ([]Station, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var station Station
+		station := Station{UserID: user}
 
-		err = rows.Scan(&station.ID, &station.Type, &station.Source)
+		err = rows.Scan(&station.ID, &station.Type, &station.Source, &station.Repeat, &station.Shuffle)
 		if err != nil {
 			return nil, err
 		}
@@ -74,10 +215,75 @@ func (server *FNRadioServer) getUserStations(user string) ([]Station, error) {
 	return stations, nil
 }
 
-func (server *FNRadioServer) getUserBindings(user string) ([]Binding, error) {
+func (store *PostgresStore) GetUserStation(user string, id string) (*Station, error) {
+	station := Station{UserID: user, ID: id}
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT type, source, repeat, shuffle FROM stations WHERE user_id = $1 AND id = $2", user, id).Scan(&station.Type, &station.Source, &station.Repeat, &station.Shuffle)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &station, nil
+}
+
+func (store *PostgresStore) CreateStation(station Station) error {
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO stations (user_id, id, type, source, repeat, shuffle) VALUES ($1, $2, $3, $4, $5, $6)", station.UserID, station.ID, station.Type, station.Source, station.Repeat, station.Shuffle)
+
+	return conflict(err)
+}
+
+func (store *PostgresStore) UpdateStationSource(user string, id string, source string) error {
+	return updated(store.DB.Exec(context.TODO(), "UPDATE stations SET source = $1 WHERE user_id = $2 AND id = $3", source, user, id))
+}
+
+func (store *PostgresStore) UpdateStationPlayback(user string, id string, repeat string, shuffle bool) error {
+	return updated(store.DB.Exec(context.TODO(), "UPDATE stations SET repeat = $1, shuffle = $2 WHERE user_id = $3 AND id = $4", repeat, shuffle, user, id))
+}
+
+func (store *PostgresStore) DeleteStation(user string, id string) error {
+	return store.DB.BeginFunc(context.TODO(), func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.TODO(), "DELETE FROM stations WHERE user_id = $1 AND id = $2", user, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(context.TODO(), "DELETE FROM bindings WHERE station_user = $1 AND station_id = $2", user, id)
+
+		return err
+	})
+}
+
+func (store *PostgresStore) SourceReferences() (map[string]int, error) {
+	references := make(map[string]int)
+
+	rows, err := store.DB.Query(context.Background(), "SELECT source, count(*) FROM (SELECT source FROM stations WHERE source IS NOT NULL UNION ALL SELECT element->>'source' FROM stream_queues, jsonb_array_elements(elements) AS element) AS sources GROUP BY source")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			source string
+			count  int
+		)
+
+		err = rows.Scan(&source, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		references[source] = count
+	}
+
+	return references, rows.Err()
+}
+
+func (store *PostgresStore) GetUserBindings(user string) ([]Binding, error) {
 	var bindings []Binding
 
-	rows, err := server.DB.Query(context.TODO(), "SELECT id, station_user, station_id FROM bindings WHERE user_id = $1", user)
+	rows, err := store.DB.Query(context.TODO(), "SELECT id, station_user, station_id FROM bindings WHERE user_id = $1", user)
 	if err != nil {
 		return nil, err
 	}
@@ -102,25 +308,241 @@ func (server *FNRadioServer) getUserBindings(user string) ([]Binding, error) {
 	return bindings, nil
 }
 
-func (server *FNRadioServer) getUserStation(user string, stationID string) *Station {
-	var station Station
+func (store *PostgresStore) GetUserBinding(user string, id string) (*Binding, error) {
+	binding := Binding{ID: id}
 
-	err := server.DB.QueryRow(context.TODO(), "SELECT type, source FROM stations WHERE user_id = $1 AND id = $2", user, stationID).Scan(&station.Type, &station.Source)
+	err := store.DB.QueryRow(context.TODO(), "SELECT station_user, station_id FROM bindings WHERE user_id = $1 AND id = $2", user, id).Scan(&binding.StationUser, &binding.StationID)
 	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &binding, nil
+}
+
+func (store *PostgresStore) PutBinding(user string, binding Binding) error {
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO bindings (user_id, id, station_user, station_id) VALUES ($1, $2, $3, $4) ON CONFLICT (user_id, id) DO UPDATE SET station_user = $3, station_id = $4", user, binding.ID, binding.StationUser, binding.StationID)
+
+	return err
+}
+
+func (store *PostgresStore) DeleteBinding(user string, id string) error {
+	_, err := store.DB.Exec(context.TODO(), "DELETE FROM bindings WHERE user_id = $1 AND id = $2", user, id)
+
+	return err
+}
+
+func (store *PostgresStore) RemoveSources(reasons map[string]string) error {
+	folders := make([]string, 0, len(reasons))
+
+	for folder := range reasons {
+		folders = append(folders, folder)
+	}
+
+	return store.DB.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), "DELETE FROM bindings USING stations WHERE bindings.station_user = stations.user_id AND bindings.station_id = stations.id AND stations.source = ANY($1)", folders)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(context.Background(), "DELETE FROM stations WHERE source = ANY($1)", folders)
+		if err != nil {
+			return err
+		}
+
+		for folder, reason := range reasons {
+			_, err = tx.Exec(context.Background(), "INSERT INTO source_removals (folder, reason) VALUES ($1, $2)", folder, reason)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
+	})
+}
+
+func (store *PostgresStore) GetQuotaOverrides(user string) (QuotaOverrides, error) {
+	var overrides QuotaOverrides
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT max_stations, max_bindings, max_queue_length, max_ingest_minutes_per_day FROM user_quotas WHERE user_id = $1", user).Scan(&overrides.MaxStations, &overrides.MaxBindings, &overrides.MaxQueueLength, &overrides.MaxIngestMinutesPerDay)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return overrides, err
+	}
+
+	return overrides, nil
+}
+
+func (store *PostgresStore) SetQuotaOverrides(user string, overrides QuotaOverrides) error {
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO user_quotas (user_id, max_stations, max_bindings, max_queue_length, max_ingest_minutes_per_day) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id) DO UPDATE SET max_stations = $2, max_bindings = $3, max_queue_length = $4, max_ingest_minutes_per_day = $5", user, overrides.MaxStations, overrides.MaxBindings, overrides.MaxQueueLength, overrides.MaxIngestMinutesPerDay)
+
+	return err
+}
+
+func (store *PostgresStore) GetIngestUsage(user string, day string) (time.Duration, error) {
+	var seconds float64
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT seconds FROM ingest_usage WHERE user_id = $1 AND day = $2::date", user, day).Scan(&seconds)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (store *PostgresStore) AddIngestUsage(user string, day string, duration time.Duration, limit time.Duration) error {
+	tag, err := store.DB.Exec(context.TODO(), "INSERT INTO ingest_usage (user_id, day, seconds) SELECT $1, $2::date, $3::double precision WHERE $4::double precision <= 0 OR $3 <= $4 ON CONFLICT (user_id, day) DO UPDATE SET seconds = ingest_usage.seconds + $3 WHERE $4 <= 0 OR ingest_usage.seconds + $3 <= $4", user, day, duration.Seconds(), limit.Seconds())
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrIngestQuotaExceeded
 	}
 
-	return &station
+	return nil
 }
 
-func (server *FNRadioServer) getUserBinding(user string, id string) *Binding {
-	var binding Binding
+func (store *PostgresStore) SaveStreamQueue(user string, station string, queue SavedStreamQueue) error {
+	if len(queue.Elements) == 0 {
+		_, err := store.DB.Exec(context.TODO(), "DELETE FROM stream_queues WHERE user_id = $1 AND station_id = $2", user, station)
 
-	err := server.DB.QueryRow(context.TODO(), "SELECT station_user, station_id FROM bindings WHERE user_id = $1 AND id = $2", user, id).Scan(&binding.StationUser, &binding.StationID)
+		return err
+	}
+
+	elements, err := json.Marshal(queue.Elements)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return err
 	}
 
-	return &binding
+	_, err = store.DB.Exec(context.TODO(), "INSERT INTO stream_queues (user_id, station_id, elements, playback_offset, updated_at) VALUES ($1, $2, $3::jsonb, $4, now()) ON CONFLICT (user_id, station_id) DO UPDATE SET elements = $3::jsonb, playback_offset = $4, updated_at = now()", user, station, string(elements), queue.Offset.Seconds())
+
+	return err
+}
+
+func (store *PostgresStore) GetStreamQueue(user string, station string) (*SavedStreamQueue, error) {
+	var (
+		elements []byte
+		seconds  float64
+	)
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT elements::text, playback_offset FROM stream_queues WHERE user_id = $1 AND station_id = $2", user, station).Scan(&elements, &seconds)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	queue := &SavedStreamQueue{Offset: time.Duration(seconds * float64(time.Second))}
+
+	err = json.Unmarshal(elements, &queue.Elements)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+func (store *PostgresStore) SaveSourceMetadata(metadata SourceMetadata) error {
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO source_metadata (folder, title, author, thumbnail, duration, updated_at) VALUES ($1, $2, $3, $4, $5, now()) ON CONFLICT (folder) DO UPDATE SET title = $2, author = $3, thumbnail = $4, duration = $5, updated_at = now()", metadata.Folder, metadata.Title, metadata.Author, metadata.Thumbnail, metadata.Duration.Seconds())
+
+	return err
+}
+
+func (store *PostgresStore) GetSourceMetadata(folders []string) (map[string]SourceMetadata, error) {
+	metadata := make(map[string]SourceMetadata)
+
+	rows, err := store.DB.Query(context.TODO(), "SELECT folder, title, coalesce(author, ''), coalesce(thumbnail, ''), duration FROM source_metadata WHERE folder = ANY($1)", folders)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var seconds float64
+
+		source := SourceMetadata{}
+
+		err = rows.Scan(&source.Folder, &source.Title, &source.Author, &source.Thumbnail, &seconds)
+		if err != nil {
+			return nil, err
+		}
+
+		source.Duration = time.Duration(seconds * float64(time.Second))
+		metadata[source.Folder] = source
+	}
+
+	return metadata, rows.Err()
+}
+
+func (store *PostgresStore) SaveMediaAccess(access map[string]time.Time) error {
+	folders := make([]string, 0, len(access))
+	times := make([]time.Time, 0, len(access))
+
+	for folder, accessedAt := range access {
+		folders = append(folders, folder)
+		times = append(times, accessedAt)
+	}
+
+	_, err := store.DB.Exec(context.TODO(), "INSERT INTO media_access (folder, accessed_at) SELECT * FROM unnest($1::text[], $2::timestamptz[]) ON CONFLICT (folder) DO UPDATE SET accessed_at = GREATEST(media_access.accessed_at, excluded.accessed_at)", folders, times)
+
+	return err
+}
+
+func (store *PostgresStore) GetMediaAccess() (map[string]time.Time, error) {
+	access := make(map[string]time.Time)
+
+	rows, err := store.DB.Query(context.TODO(), "SELECT folder, accessed_at FROM media_access")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var folder string
+
+		var accessedAt time.Time
+
+		err = rows.Scan(&folder, &accessedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		access[folder] = accessedAt
+	}
+
+	return access, rows.Err()
+}
+
+func (store *PostgresStore) DeleteMediaAccess(folders []string) error {
+	_, err := store.DB.Exec(context.TODO(), "DELETE FROM media_access WHERE folder = ANY($1)", folders)
+
+	return err
+}
+
+func (store *PostgresStore) SaveIngestJob(job IngestJob) error {
+	_, err := store.DB.Exec(context.Background(), "INSERT INTO ingest_jobs (folder, state, progress, reason, updated_at) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (folder) DO UPDATE SET state = $2, progress = $3, reason = $4, updated_at = $5", job.Folder, job.State, job.Progress, job.Reason, job.UpdatedAt)
+
+	return err
+}
+
+func (store *PostgresStore) GetIngestJob(folder string) (*IngestJob, error) {
+	var reason *string
+
+	job := &IngestJob{Folder: folder}
+
+	err := store.DB.QueryRow(context.TODO(), "SELECT state, progress, reason, updated_at FROM ingest_jobs WHERE folder = $1", folder).Scan(&job.State, &job.Progress, &reason, &job.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if reason != nil {
+		job.Reason = *reason
+	}
+
+	return job, nil
+}
+
+func (store *PostgresStore) FailUnfinishedIngestJobs(reason string) error {
+	_, err := store.DB.Exec(context.Background(), "UPDATE ingest_jobs SET state = $1, reason = $2, updated_at = $3 WHERE state <> ALL($4)", IngestStateFailed, reason, time.Now(), []string{IngestStateReady, IngestStateFailed})
+
+	return err
 }
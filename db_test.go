@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPostgresStoreConflictsAndMissingRows(t *testing.T) {
+	store := testPostgresStore(t)
+
+	user := generateID()
+
+	t.Cleanup(func() {
+		_, _ = store.DB.Exec(context.Background(), "DELETE FROM users WHERE id = $1", user)
+	})
+
+	err := store.CreateUser(User{ID: user, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CreateUser(User{ID: user, Secret: "secret"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate user, got %v", err)
+	}
+
+	station := Station{UserID: user, ID: "radio", Type: StationTypeStream}
+
+	err = store.CreateStation(station)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CreateStation(station); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate station, got %v", err)
+	}
+
+	if err := store.UpdateStationSource(user, "missing", "YT_a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating the source of a missing station, got %v", err)
+	}
+
+	if err := store.UpdateStationPlayback(user, "missing", RepeatAll, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating the playback of a missing station, got %v", err)
+	}
+
+	if err := store.UpdateStationPlayback(user, "radio", RepeatAll, true); err != nil {
+		t.Errorf("updating an existing station failed: %v", err)
+	}
+}
+
+func TestPostgresStoreMediaAccess(t *testing.T) {
+	store := testPostgresStore(t)
+
+	folder := "YT_" + generateID()
+
+	t.Cleanup(func() {
+		_ = store.DeleteMediaAccess([]string{folder})
+	})
+
+	testMediaAccess(t, store, folder)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxSourceDuration = 1 * time.Hour
+
+var (
+	ErrUnprobeableMedia = errors.New("unable to probe media")
+	ErrUnknownDuration  = errors.New("unable to determine media duration")
+	ErrLiveStream       = errors.New("live streams aren't supported")
+	ErrSourceTooLong    = errors.New("sources longer than 1 hour aren't supported")
+)
+
+func transcodeToHLS(ctx context.Context, dir string, input io.Reader, progress func(time.Duration)) error {
+	command := exec.CommandContext(ctx, "ffmpeg", "-nostats", "-progress", "pipe:1", "-protocol_whitelist", "pipe", "-i", "-", "-vn", "-hls_playlist_type", "vod", "-hls_time", "2", "-hls_segment_type", "fmp4", "-hls_flags", "discont_start", "-c:a", "libfdk_aac", "-b:a", "192k", "-master_pl_name", "master.m3u8", dir+"/output.m3u8")
+	command.Stdin = input
+
+	return runWithProgress(command, progress)
+}
+
+// runWithProgress runs an ffmpeg command started with "-progress pipe:1" and reports how much of the input has been processed.
+func runWithProgress(command *exec.Cmd, progress func(time.Duration)) error {
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = command.Start()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found || key != "out_time_us" || progress == nil {
+			continue
+		}
+
+		microseconds, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			progress(time.Duration(microseconds) * time.Microsecond)
+		}
+	}
+
+	return command.Wait()
+}
+
+func probeDuration(input string) (time.Duration, error) {
+	output, err := exec.Command("ffprobe", "-v", "error", "-protocol_whitelist", "file", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", input).Output()
+	if err != nil {
+		return 0, ErrUnprobeableMedia
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func checkDuration(duration time.Duration) error {
+	if duration <= 0 {
+		return ErrLiveStream
+	}
+
+	if duration > MaxSourceDuration {
+		return ErrSourceTooLong
+	}
+
+	return nil
+}
+
+func probeHasAudio(input string) bool {
+	output, err := exec.Command("ffprobe", "-v", "error", "-protocol_whitelist", "file", "-select_streams", "a", "-show_entries", "stream=codec_type", "-of", "csv=p=0", input).Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(output), "audio")
+}
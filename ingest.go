@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IngestStateQueued      = "queued"
+	IngestStateDownloading = "downloading"
+	IngestStateTranscoding = "transcoding"
+	IngestStateReady       = "ready"
+	IngestStateFailed      = "failed"
+)
+
+var sourceFolderRegex = regexp.MustCompile(`^[A-Z]+_[A-Za-z0-9_\-]+$`)
+
+var ErrIngestCancelled = errors.New("ingest was cancelled")
+
+type IngestJob struct {
+	Folder    string        `json:"folder"`
+	State     string        `json:"state"`
+	Progress  float64       `json:"progress"`
+	Reason    string        `json:"reason,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Duration  time.Duration `json:"-"`
+}
+
+type IngestJobManager struct {
+	store Store
+	jobs  map[string]*IngestJob
+	mu    sync.Mutex
+}
+
+func (manager *IngestJobManager) persist(job IngestJob) {
+	if manager.store == nil {
+		return
+	}
+
+	_ = manager.store.SaveIngestJob(job)
+}
+
+func (manager *IngestJobManager) update(folder string, fn func(job *IngestJob)) {
+	manager.mu.Lock()
+
+	if manager.jobs == nil {
+		manager.jobs = make(map[string]*IngestJob)
+	}
+
+	job, ok := manager.jobs[folder]
+	if !ok {
+		job = &IngestJob{
+			Folder: folder,
+			State:  IngestStateQueued,
+		}
+
+		manager.jobs[folder] = job
+	}
+
+	previousState := job.State
+
+	fn(job)
+
+	job.UpdatedAt = time.Now()
+	snapshot := *job
+
+	manager.mu.Unlock()
+
+	// Progress updates are frequent, so they only live in memory until the next state change
+	if !ok || previousState != snapshot.State {
+		manager.persist(snapshot)
+	}
+}
+
+func (manager *IngestJobManager) Queue(folder string) {
+	manager.update(folder, func(job *IngestJob) {
+		job.State = IngestStateQueued
+		job.Progress = 0
+		job.Reason = ""
+	})
+}
+
+func (manager *IngestJobManager) SetState(folder string, state string) {
+	manager.update(folder, func(job *IngestJob) {
+		job.State = state
+	})
+}
+
+func (manager *IngestJobManager) SetDuration(folder string, duration time.Duration) {
+	manager.update(folder, func(job *IngestJob) {
+		job.Duration = duration
+	})
+}
+
+// Progress records how much of the source ffmpeg has processed so far.
+func (manager *IngestJobManager) Progress(folder string, processed time.Duration) {
+	manager.update(folder, func(job *IngestJob) {
+		if job.Duration <= 0 {
+			return
+		}
+
+		progress := float64(processed) / float64(job.Duration) * 100
+		if progress > 100 {
+			progress = 100
+		}
+
+		job.Progress = progress
+	})
+}
+
+func (manager *IngestJobManager) Ready(folder string) {
+	manager.update(folder, func(job *IngestJob) {
+		job.State = IngestStateReady
+		job.Progress = 100
+		job.Reason = ""
+	})
+}
+
+// Fail marks the job as failed. Only the client safe message of reason is kept, since jobs are shown
+// to anybody asking about the source.
+func (manager *IngestJobManager) Fail(folder string, reason error) {
+	manager.update(folder, func(job *IngestJob) {
+		job.State = IngestStateFailed
+		job.Reason = sourceError(reason).Message
+	})
+}
+
+func (manager *IngestJobManager) Get(folder string) *IngestJob {
+	manager.mu.Lock()
+	job, ok := manager.jobs[folder]
+
+	if ok {
+		snapshot := *job
+		manager.mu.Unlock()
+
+		return &snapshot
+	}
+
+	manager.mu.Unlock()
+
+	if manager.store == nil {
+		return nil
+	}
+
+	job, err := manager.store.GetIngestJob(folder)
+	if err != nil {
+		return nil
+	}
+
+	return job
+}
+
+// failInterrupted marks jobs that were still running when the server stopped as failed.
+func (manager *IngestJobManager) failInterrupted() {
+	if manager.store == nil {
+		return
+	}
+
+	_ = manager.store.FailUnfinishedIngestJobs("interrupted by a server restart")
+}
+
+// failIngest records why an ingest failed and removes whatever it left behind.
+func (server *FNRadioServer) failIngest(folder string, err error) {
+	if server.Ingests.Context(folder).Err() != nil {
+		// ffmpeg reports being killed, not why
+		err = ErrIngestCancelled
+	}
+
+	server.Jobs.Fail(folder, err)
+
+	if errors.Is(err, ErrIngestCancelled) {
+		// Whoever cancelled the ingest decided what happens to the stations using the folder, only the
+		// partial download is ours to clean up
+		_ = os.RemoveAll("media/" + folder)
+		server.Cache.Forget(folder)
+	} else {
+		_ = server.removeSource(folder, err.Error())
+	}
+
+	server.Ingests.Finish(folder, err)
+}
+
+func (server *FNRadioServer) completeIngest(folder string) {
+	server.Jobs.Ready(folder)
+	server.Ingests.Finish(folder, nil)
+}
+
+type ingestCall struct {
+	started  chan struct{}
+	done     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inputs   []string
+	joined   bool
+	evicting bool
+	startErr error
+	err      error
+}
+
+// IngestRegistry makes sure only one ingest per media folder runs at a time. Every ingest gets a
+// context that is cancelled when the folder is removed while the ingest is still running.
+type IngestRegistry struct {
+	calls map[string]*ingestCall
+	mu    sync.Mutex
+}
+
+// Start calls fetch unless an ingest of folder is already in flight, in which case it waits for
+// that ingest's fetch to return and reports the same result. fetch is expected to kick off the
+// actual download in the background and have it call Finish once it's done. If the folder already
+// exists by the time it's our turn, fetch isn't called at all. If the folder is being evicted, Start
+// waits for the eviction to finish first.
+func (registry *IngestRegistry) Start(folder string, fetch func() error) error {
+	registry.mu.Lock()
+
+	for {
+		call, ok := registry.calls[folder]
+		if !ok {
+			break
+		}
+
+		call.joined = true
+
+		registry.mu.Unlock()
+
+		if call.evicting {
+			<-call.done
+
+			registry.mu.Lock()
+
+			continue
+		}
+
+		<-call.started
+
+		return call.startErr
+	}
+
+	call := registry.add(folder)
+
+	registry.mu.Unlock()
+
+	var err error
+
+	_, statErr := os.Stat("media/" + folder)
+
+	if os.IsNotExist(statErr) {
+		err = fetch()
+	}
+
+	call.startErr = err
+	close(call.started)
+
+	if err != nil || !os.IsNotExist(statErr) {
+		registry.Finish(folder, err)
+	}
+
+	return err
+}
+
+// add registers a new call for folder, registry.mu must be held.
+func (registry *IngestRegistry) add(folder string) *ingestCall {
+	if registry.calls == nil {
+		registry.calls = make(map[string]*ingestCall)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	call := &ingestCall{
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	registry.calls[folder] = call
+
+	return call
+}
+
+// SetInputs records the folders the in-flight ingest of folder is built from, e.g. the items of a
+// playlist, so they aren't evicted before it's done with them.
+func (registry *IngestRegistry) SetInputs(folder string, inputs []string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if call, ok := registry.calls[folder]; ok {
+		call.inputs = inputs
+	}
+}
+
+// InUse reports whether folder is being ingested or is an input of an ingest in flight.
+func (registry *IngestRegistry) InUse(folder string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.inUse(folder)
+}
+
+func (registry *IngestRegistry) inUse(folder string) bool {
+	if _, ok := registry.calls[folder]; ok {
+		return true
+	}
+
+	for _, call := range registry.calls {
+		for _, input := range call.inputs {
+			if input == folder {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Shared reports whether anybody besides whoever started the ingest of folder depends on it, either
+// because another ingest uses it as an input or because another Start joined it.
+func (registry *IngestRegistry) Shared(folder string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if call, ok := registry.calls[folder]; ok && call.joined {
+		return true
+	}
+
+	for _, call := range registry.calls {
+		for _, input := range call.inputs {
+			if input == folder {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ClaimEviction reserves folder for removal unless it's in use. Until Finish is called, ingests of
+// folder wait for the removal instead of finding the folder half deleted.
+func (registry *IngestRegistry) ClaimEviction(folder string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.inUse(folder) {
+		return false
+	}
+
+	call := registry.add(folder)
+	call.evicting = true
+	close(call.started)
+
+	return true
+}
+
+// Finish marks the in-flight ingest of folder as done and wakes up everybody waiting for it.
+func (registry *IngestRegistry) Finish(folder string, err error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	call, ok := registry.calls[folder]
+	if !ok {
+		return
+	}
+
+	delete(registry.calls, folder)
+
+	call.cancel()
+	call.err = err
+	close(call.done)
+}
+
+// Context returns the context of the in-flight ingest of folder, which the download and transcode
+// should run under. If nothing is in flight the returned context is already cancelled.
+func (registry *IngestRegistry) Context(folder string) context.Context {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if call, ok := registry.calls[folder]; ok {
+		return call.ctx
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return ctx
+}
+
+// Cancel aborts the in-flight ingest of folder, if there is one. The ingest still calls Finish
+// once its download and transcode have stopped.
+func (registry *IngestRegistry) Cancel(folder string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if call, ok := registry.calls[folder]; ok {
+		call.cancel()
+	}
+}
+
+func (registry *IngestRegistry) InFlight(folder string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	_, ok := registry.calls[folder]
+
+	return ok
+}
+
+// Wait blocks until the in-flight ingest of folder is done and returns its result. If nothing is in flight it returns right away.
+func (registry *IngestRegistry) Wait(ctx context.Context, folder string) error {
+	registry.mu.Lock()
+	call, ok := registry.calls[folder]
+	registry.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (server *FNRadioServer) trackProgress(folder string) func(time.Duration) {
+	return func(processed time.Duration) {
+		server.Jobs.Progress(folder, processed)
+	}
+}
+
+func (server *FNRadioServer) getSourceStatus(c *gin.Context) {
+	folder := c.Param("folder")
+	if !sourceFolderRegex.MatchString(folder) {
+		respondError(c, newAPIError(CodeInvalidRequest, "invalid source folder"))
+
+		return
+	}
+
+	job := server.Jobs.Get(folder)
+	if job == nil {
+		if _, err := os.Stat("media/" + folder + "/master.m3u8"); err == nil {
+			job = &IngestJob{
+				Folder:   folder,
+				State:    IngestStateReady,
+				Progress: 100,
+			}
+		}
+	}
+
+	if job == nil {
+		respondError(c, newAPIError(CodeNotFound, "source not found"))
+
+		return
+	}
+
+	c.JSON(200, job)
+}
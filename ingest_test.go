@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFailedJobReasonsAreSanitized(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	reasons := map[string]error{
+		"HTTP_internal": errors.New("dial tcp 10.0.0.1:80: connection refused"),
+		"HTTP_too_long": fmt.Errorf("probing: %w", ErrSourceTooLong),
+		"PL_missing":    &APIError{Code: CodeSourceUnavailable, Message: "playlist item YT_a is missing", Err: errors.New("stat media/YT_a")},
+	}
+
+	expected := map[string]string{
+		"HTTP_internal": "source could not be fetched",
+		"HTTP_too_long": ErrSourceTooLong.Error(),
+		"PL_missing":    "playlist item YT_a is missing",
+	}
+
+	for folder, reason := range reasons {
+		server.Jobs.Queue(folder)
+		server.Jobs.Fail(folder, reason)
+
+		response := server.request(t, user, "GET", "/sources/"+folder+"/status", nil)
+		expectStatus(t, response, 200, "")
+
+		var job IngestJob
+
+		err := json.Unmarshal(response.Body.Bytes(), &job)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.State != IngestStateFailed || job.Reason != expected[folder] {
+			t.Errorf("%s: expected a failed job with reason %q, got %+v", folder, expected[folder], job)
+		}
+	}
+}
+
+func TestDeleteAccountSparesSharedIngests(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+	other := server.createTestUser(t)
+
+	folder := "YT_shared"
+	source := sql.NullString{String: folder, Valid: true}
+
+	err := server.Store.CreateStation(Station{UserID: user.ID, ID: "radio", Type: StationTypeStatic, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The download keeps running in the background until somebody calls Finish
+	err = server.Ingests.Start(folder, func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Ingests.Finish(folder, nil)
+
+	// Another user creating a station from the same source joins the ingest before their station exists
+	err = server.Ingests.Start(folder, func() error {
+		t.Error("joined ingest was fetched again")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.deleteAccount(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Ingests.Context(folder).Err() != nil {
+		t.Fatal("ingest another user joined was cancelled")
+	}
+
+	err = server.Store.CreateStation(Station{UserID: other.ID, ID: "radio", Type: StationTypeStatic, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Even when an ingest does get cancelled, the stations using it stay around
+	server.Ingests.Cancel(folder)
+	server.failIngest(folder, errors.New("signal: killed"))
+
+	if _, err := server.Store.GetUserStation(other.ID, "radio"); err != nil {
+		t.Errorf("cancelled ingest took a station with it: %v", err)
+	}
+
+	if job := server.Jobs.Get(folder); job == nil || job.Reason != ErrIngestCancelled.Error() {
+		t.Errorf("expected the job to be failed as cancelled, got %+v", job)
+	}
+}
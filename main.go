@@ -3,86 +3,145 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
-	"regexp"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
-
 	"github.com/joho/godotenv"
 
 	"github.com/gin-gonic/gin"
 )
 
 type FNRadioServer struct {
-	Debug          bool
-	Router         *gin.Engine
-	DB             *pgxpool.Pool
-	StreamStations StreamStationStore
-	Parties        PartyStore
+	Debug           bool
+	Router          *gin.Engine
+	Store           Store
+	StreamStations  StreamStationStore
+	Parties         PartyStore
+	Sources         SourceRegistry
+	Jobs            IngestJobManager
+	Transcodes      TranscodeScheduler
+	Ingests         IngestRegistry
+	Cache           MediaCache
+	TokenKey        []byte
+	MediaKey        []byte
+	MediaOpenAccess bool
+	RateLimits      RateLimits
+	TrustedProxies  []string
+	DefaultQuota    Quota
+	AdminToken      string
 }
 
-func (server *FNRadioServer) getStation(c *gin.Context) {
+// checkStationAccess makes sure the requesting user owns the station in the path or is in a party led by its owner.
+func (server *FNRadioServer) checkStationAccess(c *gin.Context) bool {
 	authenticatedUser := c.MustGet("user").(User)
 	if c.Param("user") != authenticatedUser.ID {
 		party := server.Parties.GetUserParty(authenticatedUser.ID)
 		if !(party != nil && party.Members[0] == c.Param("user")) {
-			c.JSON(403, gin.H{
-				"error": "you do not have permission to get this station",
-			})
+			respondError(c, newAPIError(CodeForbidden, "you do not have permission to get this station"))
 
-			return
+			return false
 		}
 	}
 
-	station := Station{}
+	return true
+}
+
+func (server *FNRadioServer) buildStationBlurl(c *gin.Context) ([]byte, bool) {
+	if !server.checkStationAccess(c) {
+		return nil, false
+	}
 
-	err := server.DB.QueryRow(context.TODO(), "SELECT type, source FROM stations WHERE user_id = $1 AND id = $2", c.Param("user"), c.Param("station")).Scan(&station.Type, &station.Source)
+	station, err := server.Store.GetUserStation(c.Param("user"), c.Param("station"))
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+		respondError(c, orNotFound(err, errStationNotFound))
+		return nil, false
 	}
 
-	blurl, err := server.createBlurl(&station, c)
+	blurl, err := server.createBlurl(station, c)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
+		return nil, false
+	}
+
+	return blurl, true
+}
+
+func (server *FNRadioServer) getStation(c *gin.Context) {
+	blurl, ok := server.buildStationBlurl(c)
+	if !ok {
 		return
 	}
 
 	_, _ = c.Writer.Write(blurl)
 }
 
+func (server *FNRadioServer) inspectStation(c *gin.Context) {
+	blurl, ok := server.buildStationBlurl(c)
+	if !ok {
+		return
+	}
+
+	decoded, err := decodeBlurl(blurl)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.IndentedJSON(200, gin.H{
+		"size":  len(blurl),
+		"blurl": decoded,
+	})
+}
+
 const (
 	InvalidAuthorizationHeaderError = "Invalid authorization header"
 )
 
-func (server *FNRadioServer) handleAuth(c *gin.Context) {
+func (server *FNRadioServer) handleAuth(c *gin.Context) { // nolint:funlen
+	if !server.checkAuthFailures(c) {
+		return
+	}
+
 	authorization := strings.Split(c.GetHeader("Authorization"), " ")
 
-	if !strings.EqualFold(authorization[0], "basic") || len(authorization) != 2 {
-		c.AbortWithStatusJSON(401, gin.H{
-			"error": InvalidAuthorizationHeaderError,
-		})
+	if len(authorization) == 2 && strings.EqualFold(authorization[0], AuthMethodBearer) {
+		user, err := server.authenticateToken(authorization[1])
+		if err != nil {
+			server.recordAuthFailure(c)
+			respondError(c, err)
+
+			return
+		}
+
+		c.Set("user", *user)
+		c.Set("auth", AuthMethodBearer)
+		c.Next()
+
+		return
+	}
+
+	if !strings.EqualFold(authorization[0], AuthMethodBasic) || len(authorization) != 2 {
+		respondError(c, newAPIError(CodeUnauthorized, InvalidAuthorizationHeaderError))
 
 		return
 	}
 
 	decoded, err := base64.StdEncoding.DecodeString(authorization[1])
 	if err != nil {
-		c.AbortWithStatusJSON(401, gin.H{
-			"error": InvalidAuthorizationHeaderError,
-		})
+		respondError(c, newAPIError(CodeUnauthorized, InvalidAuthorizationHeaderError))
 
 		return
 	}
@@ -90,25 +149,21 @@ func (server *FNRadioServer) handleAuth(c *gin.Context) {
 	credentials := strings.Split(string(decoded), ":")
 
 	if len(credentials) != 2 {
-		c.AbortWithStatusJSON(401, gin.H{
-			"error": InvalidAuthorizationHeaderError,
-		})
+		respondError(c, newAPIError(CodeUnauthorized, InvalidAuthorizationHeaderError))
 
 		return
 	}
 
-	user := User{}
-
-	err = server.DB.QueryRow(context.TODO(), "SELECT * FROM users WHERE id = $1 AND secret = $2", credentials[0], credentials[1]).Scan(&user.ID, &user.Secret)
-	if err != nil {
-		c.AbortWithStatusJSON(401, gin.H{
-			"error": err.Error(),
-		})
+	user, ok := server.authenticate(credentials[0], credentials[1])
+	if !ok {
+		server.recordAuthFailure(c)
+		respondError(c, newAPIError(CodeUnauthorized, "invalid credentials"))
 
 		return
 	}
 
-	c.Set("user", user)
+	c.Set("user", *user)
+	c.Set("auth", AuthMethodBasic)
 	c.Next()
 }
 
@@ -116,11 +171,16 @@ func (server *FNRadioServer) createUser(c *gin.Context) {
 	id := generateID()
 	secret := generateID()
 
-	_, err := server.DB.Query(context.TODO(), "INSERT INTO users (id, secret) VALUES ($1, $2)", id, secret)
+	hash, err := hashSecret(secret)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
+
+		return
+	}
+
+	err = server.Store.CreateUser(User{ID: id, Secret: hash})
+	if err != nil {
+		respondError(c, err)
 
 		return
 	}
@@ -134,22 +194,26 @@ func (server *FNRadioServer) createUser(c *gin.Context) {
 func (server *FNRadioServer) getCurrentUser(c *gin.Context) {
 	currentUser := c.MustGet("user").(User)
 
-	stations, err := server.getUserStations(currentUser.ID)
+	stations, err := server.Store.GetUserStations(currentUser.ID)
 
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
 		return
 	}
 
-	bindings, err := server.getUserBindings(currentUser.ID)
+	bindings, err := server.Store.GetUserBindings(currentUser.ID)
 
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
+
+		return
+	}
+
+	quota, err := server.getQuotaStatus(currentUser.ID, len(stations), len(bindings))
+
+	if err != nil {
+		respondError(c, err)
 
 		return
 	}
@@ -168,16 +232,15 @@ func (server *FNRadioServer) getCurrentUser(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"stations": stationsMap,
 		"bindings": bindingsMap,
+		"quota":    quota,
 	})
 }
 
 func (server *FNRadioServer) getPartyLeader(c *gin.Context, userToGet string) {
-	bindings, err := server.getUserBindings(userToGet)
+	bindings, err := server.Store.GetUserBindings(userToGet)
 
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
 		return
 	}
@@ -209,9 +272,7 @@ func (server *FNRadioServer) getUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(403, gin.H{
-		"error": "you do not have permission to get this user",
-	})
+	respondError(c, newAPIError(CodeForbidden, "you do not have permission to get this user"))
 }
 
 type createStationPayload struct {
@@ -224,36 +285,12 @@ type bindStationPayload struct {
 	StationID   string `json:"station_id"`
 }
 
-func (server *FNRadioServer) handleYouTubeSource(id string) ([]string, error) {
-	folder := "YT_" + id
-
-	if _, err := os.Stat("media/" + folder); os.IsNotExist(err) {
-		err := server.startYouTubeDownload(id)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return []string{folder}, nil
-}
-
-func (server *FNRadioServer) getSourceStreams(source string) ([]string, error) {
-	ytID, _ := extractYouTubeID(source)
-
-	if ytID != "" {
-		return server.handleYouTubeSource(ytID)
-	}
-
-	ytPlaylist, _ := extractYouTubePlaylistID(source)
-	if ytPlaylist != "" {
-		return server.handleYouTubePlaylist(ytPlaylist)
-	}
-
-	return nil, errors.New("invalid source")
+func (server *FNRadioServer) getSourceStreams(user string, source string) ([]string, error) {
+	return server.Sources.Resolve(user, source)
 }
 
-func (server *FNRadioServer) getSourceStream(source string) (string, error) {
-	folders, err := server.getSourceStreams(source)
+func (server *FNRadioServer) getSourceStream(user string, source string) (string, error) {
+	folders, err := server.getSourceStreams(user, source)
 	if err != nil {
 		return "", err
 	}
@@ -264,83 +301,86 @@ func (server *FNRadioServer) getSourceStream(source string) (string, error) {
 	case 1:
 		return folders[0], nil
 	default:
-		return server.getPlaylistStream(folders)
+		return server.getPlaylistStream(user, folders)
 	}
 }
 
-func (server *FNRadioServer) nukeSource(folder string) {
-	_ = os.RemoveAll("media/" + folder)
+func (server *FNRadioServer) createPlaylistStream(user string, folder string, sources []string) { // nolint:funlen
+	ctx := server.Ingests.Context(folder)
 
-	rows, err := server.DB.Query(context.Background(), "SELECT user_id, id FROM stations WHERE source = $1", folder)
-	if err != nil {
-		return
-	}
-
-	defer rows.Close()
-
-	for rows.Next() {
-		var stationUser, stationID string
+	for _, source := range sources {
+		err := server.Ingests.Wait(ctx, source)
+		if err == nil {
+			_, err = os.Stat("media/" + source + "/master.m3u8")
+		}
 
-		err := rows.Scan(&stationUser, &stationID)
 		if err != nil {
-			continue
+			server.failIngest(folder, &APIError{Code: CodeSourceUnavailable, Message: "playlist item " + source + " is missing", Err: err})
+			return
 		}
-
-		_, _ = server.DB.Exec(context.Background(), "DELETE FROM bindings WHERE station_user = $1 AND station_id = $2", stationUser, stationID)
 	}
 
-	_, _ = server.DB.Exec(context.Background(), "SELECT FROM stations WHERE source = $1", folder)
-}
-
-func (server *FNRadioServer) createPlaylistStream(folder string, sources []string) {
-OUTER:
-	for {
-		for _, source := range sources {
-			if _, err := os.Stat("media/" + source); os.IsNotExist(err) {
-				server.nukeSource(folder)
-				return
-			}
-
-			if _, err := os.Stat("media/" + source + "/master.m3u8"); os.IsNotExist(err) {
-				time.Sleep(time.Second)
-				continue OUTER
-			}
-		}
-
-		break
-	}
+	var duration int
 
 	playlistEntries := make([]string, 0)
 	for _, source := range sources {
 		playlistEntries = append(playlistEntries, "file '../"+source+"/master.m3u8'")
+
+		output, err := os.ReadFile("media/" + source + "/output.m3u8")
+		if err == nil {
+			sourceDuration, _ := getDuration(string(output))
+			duration += sourceDuration
+		}
 	}
 
 	err := os.WriteFile("media/"+folder+"/playlist.txt", []byte(strings.Join(playlistEntries, "\n")), 0644)
 	if err != nil {
-		server.nukeSource(folder)
+		server.failIngest(folder, err)
 		return
 	}
 
-	command := exec.Command("ffmpeg", "-f", "concat", "-safe", "0", "-i", "media/"+folder+"/playlist.txt", "-hls_playlist_type", "vod", "-hls_time", "2", "-hls_segment_type", "fmp4", "-hls_flags", "discont_start", "-c:a", "copy", "-master_pl_name", "master.m3u8", "media/"+folder+"/output.m3u8")
+	server.Jobs.SetDuration(folder, time.Duration(duration)*time.Second)
 
-	err = command.Run()
+	release, err := server.Transcodes.Acquire(ctx, TranscodeTask{User: user, Folder: folder})
 	if err != nil {
-		server.nukeSource(folder)
+		server.failIngest(folder, err)
 		return
 	}
+
+	defer release()
+
+	server.Jobs.SetState(folder, IngestStateTranscoding)
+
+	command := exec.CommandContext(ctx, "ffmpeg", "-nostats", "-progress", "pipe:1", "-f", "concat", "-safe", "0", "-i", "media/"+folder+"/playlist.txt", "-hls_playlist_type", "vod", "-hls_time", "2", "-hls_segment_type", "fmp4", "-hls_flags", "discont_start", "-c:a", "copy", "-master_pl_name", "master.m3u8", "media/"+folder+"/output.m3u8")
+
+	err = runWithProgress(command, server.trackProgress(folder))
+	if err != nil {
+		server.failIngest(folder, err)
+		return
+	}
+
+	server.completeIngest(folder)
 }
 
-func (server *FNRadioServer) getPlaylistStream(sources []string) (string, error) {
+func (server *FNRadioServer) getPlaylistStream(user string, sources []string) (string, error) {
 	hash := sha256.Sum256([]byte(strings.Join(sources, "\n")))
 	folder := "PL_" + hex.EncodeToString(hash[:16])
 
-	if _, err := os.Stat("media/" + folder); os.IsNotExist(err) {
-		err = os.Mkdir("media/"+folder, 0755)
+	err := server.Ingests.Start(folder, func() error {
+		err := os.Mkdir("media/"+folder, 0755)
 		if err != nil {
-			return "", err
+			return err
 		}
 
-		go server.createPlaylistStream(folder, sources)
+		server.Jobs.Queue(folder)
+		server.Ingests.SetInputs(folder, sources)
+
+		go server.createPlaylistStream(user, folder, sources)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	return folder, nil
@@ -349,32 +389,40 @@ func (server *FNRadioServer) getPlaylistStream(sources []string) (string, error)
 func (server *FNRadioServer) createStation(c *gin.Context) { // nolint:funlen
 	var payload createStationPayload
 
-	err := c.BindJSON(&payload)
+	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	err = payload.Validate(c.Param("station"))
+	if err != nil {
+		respondError(c, err)
+
+		return
 	}
 
 	user := c.MustGet("user").(User)
-	existing := server.getUserStation(user.ID, c.Param("station"))
+	existing, err := server.Store.GetUserStation(user.ID, c.Param("station"))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		respondError(c, err)
+
+		return
+	}
 
 	if existing != nil {
 		if payload.Type == StationTypeStatic && existing.Type == StationTypeStatic {
-			stream, err := server.getSourceStream(payload.Source)
+			stream, err := server.getSourceStream(user.ID, payload.Source)
 			if err != nil {
-				c.JSON(400, gin.H{
-					"error": err.Error(),
-				})
+				respondError(c, sourceError(err))
 
 				return
 			}
 
-			_, err = server.DB.Exec(context.TODO(), "UPDATE stations SET source = $1 WHERE user_id = $2 AND id = $3", stream, user.ID, c.Param("station"))
+			err = server.Store.UpdateStationSource(user.ID, c.Param("station"), stream)
 			if err != nil {
-				c.JSON(500, gin.H{
-					"error": err.Error(),
-				})
+				respondError(c, orNotFound(err, errStationNotFound))
 
 				return
 			}
@@ -384,9 +432,14 @@ func (server *FNRadioServer) createStation(c *gin.Context) { // nolint:funlen
 			return
 		}
 
-		c.JSON(409, gin.H{
-			"error": "station already exists",
-		})
+		respondError(c, errStationExists)
+
+		return
+	}
+
+	err = server.checkStationQuota(user.ID)
+	if err != nil {
+		respondError(c, err)
 
 		return
 	}
@@ -395,29 +448,29 @@ func (server *FNRadioServer) createStation(c *gin.Context) { // nolint:funlen
 
 	switch payload.Type {
 	case StationTypeStatic:
-		source, err = server.getSourceStream(payload.Source)
+		source, err = server.getSourceStream(user.ID, payload.Source)
 		if err != nil {
-			c.JSON(400, gin.H{
-				"error": err.Error(),
-			})
+			respondError(c, sourceError(err))
 
 			return
 		}
 	case StationTypeStream:
 	// NOOP:
 	default:
-		c.JSON(400, gin.H{
-			"error": "invalid station type",
-		})
+		respondError(c, newAPIError(CodeInvalidRequest, "invalid station type"))
 
 		return
 	}
 
-	_, err = server.DB.Exec(context.TODO(), "INSERT INTO stations (user_id, id, type, source) VALUES ($1, $2, $3, $4)", user.ID, c.Param("station"), payload.Type, source)
+	err = server.Store.CreateStation(Station{
+		UserID: user.ID,
+		ID:     c.Param("station"),
+		Type:   payload.Type,
+		Source: sql.NullString{String: source, Valid: source != ""},
+		Repeat: RepeatOff,
+	})
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, orConflict(err, errStationExists))
 
 		return
 	}
@@ -428,30 +481,24 @@ func (server *FNRadioServer) createStation(c *gin.Context) { // nolint:funlen
 func (server *FNRadioServer) deleteStation(c *gin.Context) {
 	user := c.MustGet("user").(User)
 
-	station := server.getUserStation(user.ID, c.Param("station"))
-	if station == nil {
-		c.JSON(404, gin.H{
-			"error": "station not found",
-		})
+	station, err := server.Store.GetUserStation(user.ID, c.Param("station"))
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
 
 		return
 	}
 
-	_, err := server.DB.Exec(context.TODO(), "DELETE FROM stations WHERE user_id = $1 AND id = $2", user.ID, c.Param("station"))
+	err = server.Store.DeleteStation(user.ID, c.Param("station"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
 		return
 	}
 
-	_, _ = server.DB.Exec(context.TODO(), "DELETE FROM bindings WHERE station_user = $1 AND station_id = $2", user.ID, c.Param("station"))
-
 	if station.Type == StationTypeStream {
 		streamStation := server.StreamStations.Get(station)
 		if streamStation != nil {
-			streamStation.Quit <- struct{}{}
+			streamStation.Discard()
 			server.StreamStations.Remove(streamStation)
 		}
 	}
@@ -466,37 +513,58 @@ type addToQueuePayload struct {
 func (server *FNRadioServer) addToQueue(c *gin.Context) {
 	var payload addToQueuePayload
 
-	err := c.BindJSON(&payload)
+	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		respondError(c, err)
+
+		return
 	}
 
 	user := c.MustGet("user").(User)
 
-	station := server.getUserStation(user.ID, c.Param("station"))
-	if station == nil {
-		c.JSON(404, gin.H{
-			"error": "station not found",
-		})
+	station, err := server.Store.GetUserStation(user.ID, c.Param("station"))
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
 
 		return
 	}
 
 	if station.Type != StationTypeStream {
-		c.JSON(400, gin.H{
-			"error": "station type must be " + StationTypeStream,
-		})
+		respondError(c, newAPIError(CodeInvalidRequest, "station type must be "+StationTypeStream))
 
 		return
 	}
 
-	sources, err := server.getSourceStreams(payload.Source)
+	queued := 0
+	// Restoring a saved queue first keeps its elements from slipping past the quota
+	if streamStation := server.StreamStations.GetOrRestore(station); streamStation != nil {
+		queued = streamStation.Queue.Len()
+	}
+
+	err = server.checkQueueQuota(user.ID, queued+1)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
+
+		return
+	}
+
+	sources, err := server.getSourceStreams(user.ID, payload.Source)
+	if err != nil {
+		respondError(c, sourceError(err))
+
+		return
+	}
+
+	err = server.checkQueueQuota(user.ID, queued+len(sources))
+	if err != nil {
+		respondError(c, err)
 
 		return
 	}
@@ -504,11 +572,7 @@ func (server *FNRadioServer) addToQueue(c *gin.Context) {
 	streamStation := server.StreamStations.GetOrCreate(station)
 
 	for _, source := range sources {
-		streamStation.Queue.Add(&StreamQueueElement{
-			source: source,
-			data:   make([]byte, 0),
-			mu:     sync.Mutex{},
-		})
+		streamStation.Queue.Add(newStreamQueueElement(user.ID, source, &server.Transcodes))
 	}
 
 	c.Status(204)
@@ -517,39 +581,51 @@ func (server *FNRadioServer) addToQueue(c *gin.Context) {
 func (server *FNRadioServer) createBinding(c *gin.Context) {
 	var payload bindStationPayload
 
-	err := c.BindJSON(&payload)
+	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	err = payload.Validate(c.Param("binding"))
+	if err != nil {
+		respondError(c, err)
+
+		return
 	}
 
 	user := c.MustGet("user").(User)
 
 	if payload.StationUser != user.ID {
-		c.JSON(403, gin.H{
-			"error": "station must belong to the requesting user",
-		})
+		respondError(c, newAPIError(CodeForbidden, "station must belong to the requesting user"))
 
 		return
 	}
 
-	station := server.getUserStation(payload.StationUser, payload.StationID)
-	if station == nil {
-		c.JSON(404, gin.H{
-			"error": "station not found",
-		})
+	_, err = server.Store.GetUserStation(payload.StationUser, payload.StationID)
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
 
 		return
 	}
 
-	_, _ = server.DB.Exec(context.TODO(), "DELETE FROM bindings WHERE user_id = $1 AND id = $2", user.ID, c.Param("binding"))
+	if _, err := server.Store.GetUserBinding(user.ID, c.Param("binding")); errors.Is(err, ErrNotFound) {
+		err = server.checkBindingQuota(user.ID)
+		if err != nil {
+			respondError(c, err)
+
+			return
+		}
+	}
 
-	_, err = server.DB.Exec(context.TODO(), "INSERT INTO bindings (user_id, id, station_user, station_id) VALUES ($1, $2, $3, $4)", user.ID, c.Param("binding"), payload.StationUser, payload.StationID)
+	err = server.Store.PutBinding(user.ID, Binding{
+		ID:          c.Param("binding"),
+		StationUser: payload.StationUser,
+		StationID:   payload.StationID,
+	})
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
 		return
 	}
@@ -560,20 +636,16 @@ func (server *FNRadioServer) createBinding(c *gin.Context) {
 func (server *FNRadioServer) deleteBinding(c *gin.Context) {
 	user := c.MustGet("user").(User)
 
-	binding := server.getUserBinding(user.ID, c.Param("binding"))
-	if binding == nil {
-		c.JSON(404, gin.H{
-			"error": "binding not found",
-		})
+	_, err := server.Store.GetUserBinding(user.ID, c.Param("binding"))
+	if err != nil {
+		respondError(c, orNotFound(err, errBindingNotFound))
 
 		return
 	}
 
-	_, err := server.DB.Exec(context.TODO(), "DELETE FROM bindings WHERE user_id = $1 AND id = $2", user.ID, c.Param("binding"))
+	err = server.Store.DeleteBinding(user.ID, c.Param("binding"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 
 		return
 	}
@@ -581,26 +653,14 @@ func (server *FNRadioServer) deleteBinding(c *gin.Context) {
 	c.Status(204)
 }
 
-var streamRegex = regexp.MustCompile(`^/media/(STR_[0-9a-f]{32})/`)
-
-func (server *FNRadioServer) handleMedia(c *gin.Context) {
-	match := streamRegex.FindStringSubmatch(c.Request.URL.Path)
-	if len(match) > 0 {
-		streamStation := server.StreamStations.GetByFolder(match[1])
-		if streamStation != nil {
-			streamStation.LastRequest = time.Now()
-		}
-	}
-}
-
 func (server *FNRadioServer) setParty(c *gin.Context) {
 	var clientParty ClientParty
 
-	err := c.BindJSON(&clientParty)
+	err := c.ShouldBindJSON(&clientParty)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, invalidRequest(err))
+
+		return
 	}
 
 	user := c.MustGet("user").(User)
@@ -608,19 +668,16 @@ func (server *FNRadioServer) setParty(c *gin.Context) {
 	server.Parties.RemoveUser(user.ID)
 
 	if clientParty.Match != "" {
-		if !clientParty.Validate() {
-			c.JSON(400, gin.H{
-				"error": err.Error(),
-			})
+		err = clientParty.Validate()
+		if err != nil {
+			respondError(c, err)
 
 			return
 		}
 
 		party, err := server.Parties.CreateOrJoinParty(user.ID, clientParty)
 		if err != nil {
-			c.JSON(400, gin.H{
-				"error": err.Error(),
-			})
+			respondError(c, err)
 
 			return
 		}
@@ -628,12 +685,14 @@ func (server *FNRadioServer) setParty(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"leader": party.Members[0],
 		})
+
+		return
 	}
 
 	c.Status(204)
 }
 
-func (server *FNRadioServer) setupRouter() {
+func (server *FNRadioServer) setupRouter() { // nolint:funlen
 	if server.Debug {
 		gin.SetMode(gin.DebugMode)
 	} else {
@@ -642,6 +701,11 @@ func (server *FNRadioServer) setupRouter() {
 
 	server.Router = gin.New()
 
+	err := server.Router.SetTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
 	if server.Debug {
 		server.Router.Use(gin.Logger())
 	}
@@ -653,27 +717,77 @@ func (server *FNRadioServer) setupRouter() {
 		}
 	}
 
-	server.Router.Use(server.handleMedia)
+	server.Router.GET("/media/*filepath", server.handleMediaSignature, server.serveMedia)
+
+	server.Router.HEAD("/media/*filepath", server.handleMediaSignature, server.serveMedia)
+
+	accounts := server.rateLimit(server.RateLimits.Accounts)
+	ingest := server.rateLimit(server.RateLimits.Ingest)
+	read := server.rateLimit(server.RateLimits.Read)
+
+	server.Router.POST("/users", accounts, server.createUser)
+
+	server.Router.GET("/users/:user", server.handleAuth, read, server.getUser)
 
-	server.Router.Static("/media", "media")
+	server.Router.DELETE("/users/@me", server.handleAuth, server.deleteCurrentUser)
 
-	server.Router.POST("/users", server.createUser)
+	server.Router.POST("/users/@me/secret", server.handleAuth, server.rotateSecret)
 
-	server.Router.GET("/users/:user", server.handleAuth, server.getUser)
+	server.Router.POST("/users/@me/tokens", server.handleAuth, server.createUserToken)
 
-	server.Router.GET("/users/:user/stations/:station", server.handleAuth, server.getStation)
+	server.Router.DELETE("/users/@me/tokens", server.handleAuth, server.revokeUserTokens)
 
-	server.Router.PUT("/users/@me/stations/:station", server.handleAuth, server.createStation)
+	server.Router.GET("/users/:user/stations/:station", server.handleAuth, read, server.getStation)
+
+	server.Router.GET("/users/:user/stations/:station/blurl", server.handleAuth, read, server.inspectStation)
+
+	server.Router.GET("/users/:user/stations/:station/now-playing", server.handleAuth, read, server.getNowPlaying)
+
+	server.Router.PUT("/users/@me/stations/:station", server.handleAuth, ingest, server.createStation)
 
 	server.Router.DELETE("/users/@me/stations/:station", server.handleAuth, server.deleteStation)
 
-	server.Router.PUT("/users/@me/stations/:station/queue", server.handleAuth, server.addToQueue)
+	server.Router.PUT("/users/@me/stations/:station/queue", server.handleAuth, ingest, server.addToQueue)
+
+	server.Router.GET("/users/@me/stations/:station/queue", server.handleAuth, read, server.getQueue)
+
+	server.Router.PATCH("/users/@me/stations/:station/playback", server.handleAuth, server.setPlayback)
+
+	server.Router.POST("/users/@me/stations/:station/queue/skip", server.handleAuth, server.skipQueueElement)
+
+	server.Router.PATCH("/users/@me/stations/:station/queue/:element", server.handleAuth, server.moveQueueElement)
+
+	server.Router.DELETE("/users/@me/stations/:station/queue/:element", server.handleAuth, server.removeFromQueue)
 
 	server.Router.PUT("/users/@me/bindings/:binding", server.handleAuth, server.createBinding)
 
 	server.Router.DELETE("/users/@me/bindings/:binding", server.handleAuth, server.deleteBinding)
 
 	server.Router.POST("/users/@me/party", server.handleAuth, server.setParty)
+
+	server.Router.POST("/users/@me/uploads", server.handleAuth, ingest, server.uploadAudio)
+
+	server.Router.GET("/sources/:folder/status", server.handleAuth, read, server.getSourceStatus)
+
+	admin := server.Router.Group("/admin", server.handleAdminAuth)
+
+	admin.GET("/users", server.adminListUsers)
+
+	admin.DELETE("/users/:user", server.adminDeleteUser)
+
+	admin.GET("/users/:user/quota", server.adminGetQuota)
+
+	admin.PUT("/users/:user/quota", server.adminSetQuota)
+
+	admin.GET("/stations", server.adminListStations)
+
+	admin.GET("/streams", server.adminListStreams)
+
+	admin.DELETE("/streams/:user/:station", server.adminStopStream)
+
+	admin.POST("/cleanup", server.adminCleanupBrokenStations)
+
+	admin.GET("/cache", server.adminCacheReport)
 }
 
 func (server *FNRadioServer) Destroy() {
@@ -684,23 +798,115 @@ func main() {
 	_ = godotenv.Load()
 
 	debugPtr := flag.Bool("debug", false, "")
+	decodeBlurlPtr := flag.String("decode-blurl", "", "decode a blurl file (or - for stdin) and print it as JSON")
+	cacheReportPtr := flag.Bool("cache-report", false, "print which media folders would be evicted based on saved access times and exit")
 
 	flag.Parse()
 
+	if *decodeBlurlPtr != "" {
+		err := printBlurlFile(*decodeBlurlPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	server := FNRadioServer{
 		Debug: *debugPtr,
+		StreamStations: StreamStationStore{
+			Crossfade: crossfadeDuration(),
+		},
+		Transcodes: TranscodeScheduler{
+			Limit: transcodeConcurrency(),
+		},
+		Cache: MediaCache{
+			MaxBytes: mediaCacheMaxBytes(),
+			MaxAge:   mediaCacheMaxAge(),
+		},
+		TokenKey:        loadSigningKey("TOKEN_SIGNING_KEY"),
+		MediaKey:        loadSigningKey("MEDIA_SIGNING_KEY"),
+		MediaOpenAccess: mediaOpenAccess(),
+		RateLimits:      loadRateLimits(),
+		TrustedProxies:  loadTrustedProxies(),
+		DefaultQuota:    loadDefaultQuota(),
+		AdminToken:      loadAdminToken(),
+	}
+
+	server.setupSources()
+
+	if flag.Arg(0) == "migrate" {
+		err := connectPostgres().runMigrateCommand(flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if *cacheReportPtr {
+		// This runs next to a live server, so it mustn't migrate or fail the server's ingest jobs
+		server.connectStore(false)
+
+		err := server.Cache.Load()
+		if err == nil {
+			err = server.printCacheReport()
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
 	}
 
+	server.setupStore()
+
 	server.cleanupBrokenStations()
 
 	server.cleanupStreamStations()
 
 	server.setupRouter()
 
-	server.setupDB()
+	// Even without a cache budget, media left behind by deleted accounts still has to go
+	interval, err := time.ParseDuration(os.Getenv("MEDIA_CACHE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
 
-	err := http.ListenAndServe(os.Getenv("LISTEN_ADDRESS"), server.Router)
-	if err != nil {
+	go server.runCacheEviction(interval)
+
+	httpServer := &http.Server{Addr: os.Getenv("LISTEN_ADDRESS"), Handler: server.Router}
+	shutdown := make(chan struct{})
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = httpServer.Shutdown(ctx)
+
+		close(shutdown)
+	}()
+
+	err = httpServer.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
 		panic(err)
 	}
+
+	<-shutdown
+
+	// Queues and access times are only saved every so often, so catch up on what changed since
+	server.StreamStations.SaveAll()
+
+	err = server.Cache.Save()
+	if err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestServer builds a server backed by a MemoryStore with every rate limit disabled.
+func newTestServer(t *testing.T) *FNRadioServer {
+	t.Helper()
+
+	store := NewMemoryStore()
+
+	server := &FNRadioServer{
+		Store:        store,
+		TokenKey:     []byte("test token signing key"),
+		MediaKey:     []byte("test media signing key"),
+		DefaultQuota: loadDefaultQuota(),
+		AdminToken:   "admin",
+	}
+
+	server.Jobs.store = store
+	server.StreamStations.persistence = store
+	server.StreamStations.scheduler = &server.Transcodes
+	server.Cache.persistence = store
+
+	server.setupSources()
+	server.setupRouter()
+
+	return server
+}
+
+type testUser struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+func newJSONRequest(t *testing.T, method string, path string, body interface{}) *http.Request {
+	t.Helper()
+
+	var encoded []byte
+
+	if body != nil {
+		var err error
+
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+
+	return request
+}
+
+func (server *FNRadioServer) serve(request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.Router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// request sends a request with an optional JSON body, authenticated as user if it isn't nil.
+func (server *FNRadioServer) request(t *testing.T, user *testUser, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := newJSONRequest(t, method, path, body)
+
+	if user != nil {
+		request.SetBasicAuth(user.ID, user.Secret)
+	}
+
+	return server.serve(request)
+}
+
+// adminRequest sends a request to the admin API with the admin token of newTestServer.
+func (server *FNRadioServer) adminRequest(t *testing.T, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := newJSONRequest(t, method, path, body)
+	request.Header.Set("Authorization", AuthMethodBearer+" "+server.AdminToken)
+
+	return server.serve(request)
+}
+
+func (server *FNRadioServer) createTestUser(t *testing.T) *testUser {
+	t.Helper()
+
+	response := server.request(t, nil, "POST", "/users", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("creating a user failed with %d: %s", response.Code, response.Body)
+	}
+
+	var user testUser
+
+	err := json.Unmarshal(response.Body.Bytes(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &user
+}
+
+func expectStatus(t *testing.T, response *httptest.ResponseRecorder, status int, code ErrorCode) {
+	t.Helper()
+
+	if response.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, response.Code, response.Body)
+	}
+
+	if code == "" {
+		return
+	}
+
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+
+	_ = json.Unmarshal(response.Body.Bytes(), &body)
+
+	if body.Code != code {
+		t.Fatalf("expected error code %s, got %s", code, body.Code)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "GET", "/users/@me", nil), 200, "")
+	expectStatus(t, server.request(t, nil, "GET", "/users/@me", nil), 401, CodeUnauthorized)
+	expectStatus(t, server.request(t, &testUser{ID: user.ID, Secret: "wrong"}, "GET", "/users/@me", nil), 401, CodeUnauthorized)
+}
+
+func TestBearerTokensCantTakeOverAccounts(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	response := server.request(t, user, "POST", "/users/@me/tokens", nil)
+	expectStatus(t, response, 200, "")
+
+	var body struct {
+		Token string `json:"token"`
+	}
+
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearerRequest := func(method string, path string) *httptest.ResponseRecorder {
+		request := newJSONRequest(t, method, path, nil)
+		request.Header.Set("Authorization", AuthMethodBearer+" "+body.Token)
+
+		return server.serve(request)
+	}
+
+	expectStatus(t, bearerRequest("GET", "/users/@me"), 200, "")
+	expectStatus(t, bearerRequest("POST", "/users/@me/tokens"), 403, CodeForbidden)
+	expectStatus(t, bearerRequest("POST", "/users/@me/secret"), 403, CodeForbidden)
+	expectStatus(t, bearerRequest("DELETE", "/users/@me"), 403, CodeForbidden)
+
+	// The secret still works, so the owner wasn't locked out
+	expectStatus(t, server.request(t, user, "GET", "/users/@me", nil), 200, "")
+}
+
+func TestStationLifecycle(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	stream := gin.H{"type": StationTypeStream}
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", stream), 204, "")
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", stream), 409, CodeConflict)
+
+	expectStatus(t, server.request(t, user, "PATCH", "/users/@me/stations/radio/playback", gin.H{"repeat": RepeatAll, "shuffle": true}), 200, "")
+
+	station, err := server.Store.GetUserStation(user.ID, "radio")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if station.Repeat != RepeatAll || !station.Shuffle {
+		t.Errorf("playback wasn't saved: %+v", station)
+	}
+
+	expectStatus(t, server.request(t, user, "PATCH", "/users/@me/stations/missing/playback", gin.H{"shuffle": true}), 404, CodeNotFound)
+
+	// Blurls need to know where the API is reachable
+	expectStatus(t, server.request(t, user, "GET", "/users/"+user.ID+"/stations/radio/blurl", nil), 400, CodeInvalidRequest)
+
+	expectStatus(t, server.request(t, user, "DELETE", "/users/@me/stations/radio", nil), 204, "")
+	expectStatus(t, server.request(t, user, "DELETE", "/users/@me/stations/radio", nil), 404, CodeNotFound)
+}
+
+func TestStationValidation(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": "vinyl"}), 400, CodeInvalidRequest)
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": StationTypeStream, "source": "upload:abc"}), 400, CodeInvalidRequest)
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", "not an object"), 400, CodeInvalidRequest)
+}
+
+func TestBindings(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+	other := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": StationTypeStream}), 204, "")
+
+	binding := gin.H{"station_user": user.ID, "station_id": "radio"}
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/bindings/car", binding), 204, "")
+	expectStatus(t, server.request(t, other, "PUT", "/users/@me/bindings/car", binding), 403, CodeForbidden)
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/bindings/car", gin.H{"station_user": user.ID, "station_id": "missing"}), 404, CodeNotFound)
+
+	response := server.request(t, user, "GET", "/users/@me", nil)
+	expectStatus(t, response, 200, "")
+
+	var body struct {
+		Bindings map[string]Binding `json:"bindings"`
+	}
+
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Bindings["car"].StationID != "radio" {
+		t.Errorf("binding wasn't saved: %+v", body.Bindings)
+	}
+
+	// Deleting the station takes the binding with it
+	expectStatus(t, server.request(t, user, "DELETE", "/users/@me/stations/radio", nil), 204, "")
+
+	if _, err := server.Store.GetUserBinding(user.ID, "car"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("binding outlived its station: %v", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": StationTypeStream}), 204, "")
+	expectStatus(t, server.request(t, user, "DELETE", "/users/@me", nil), 204, "")
+	expectStatus(t, server.request(t, user, "GET", "/users/@me", nil), 401, CodeUnauthorized)
+
+	stations, err := server.Store.ListStations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stations) != 0 {
+		t.Errorf("stations outlived their user: %+v", stations)
+	}
+}
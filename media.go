@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signed media paths look like /media/s/<expires>/<signature>/<folder>/<file>. The signature only
+// covers the folder, so the relative URLs inside the HLS playlists keep working.
+var signedMediaRegex = regexp.MustCompile(`^/s/(\d+)/([A-Za-z0-9_\-]+)/([A-Za-z0-9_\-]+)(/.*)$`)
+
+func mediaURLTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("MEDIA_URL_TTL"))
+	if err != nil || ttl <= 0 {
+		return 6 * time.Hour
+	}
+
+	return ttl
+}
+
+func mediaOpenAccess() bool {
+	open, _ := strconv.ParseBool(os.Getenv("MEDIA_OPEN_ACCESS"))
+
+	return open
+}
+
+func signMediaFolder(key []byte, folder string, expires int64) string {
+	return signToken(key, folder+":"+strconv.FormatInt(expires, 10))
+}
+
+// mediaURL returns the path a client should use to fetch a media folder.
+func (server *FNRadioServer) mediaURL(folder string) string {
+	expires := time.Now().Add(mediaURLTTL()).Unix()
+
+	return "/media/s/" + strconv.FormatInt(expires, 10) + "/" + signMediaFolder(server.MediaKey, folder, expires) + "/" + url.PathEscape(folder)
+}
+
+// handleMediaSignature checks the signature of signed media paths and rewrites them to the file
+// they point to. Unsigned paths are only let through when open access is enabled.
+func (server *FNRadioServer) handleMediaSignature(c *gin.Context) {
+	file := c.Param("filepath")
+
+	match := signedMediaRegex.FindStringSubmatch(file)
+	if match == nil {
+		if !server.MediaOpenAccess {
+			respondError(c, newAPIError(CodeForbidden, "media URL must be signed"))
+
+			return
+		}
+
+		c.Set("mediaFile", file)
+		c.Next()
+
+		return
+	}
+
+	expires, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil || !hmac.Equal([]byte(match[2]), []byte(signMediaFolder(server.MediaKey, match[3], expires))) {
+		respondError(c, newAPIError(CodeForbidden, "invalid media signature"))
+
+		return
+	}
+
+	if time.Now().Unix() > expires {
+		respondError(c, newAPIError(CodeForbidden, "media URL expired"))
+
+		return
+	}
+
+	// The signature only covers the folder, so the rest of the path must not be able to leave it
+	folder := "/" + match[3] + "/"
+
+	file = path.Clean(folder + match[4])
+	if strings.HasSuffix(match[4], "/") {
+		file += "/"
+	}
+
+	if !strings.HasPrefix(file, folder) {
+		respondError(c, newAPIError(CodeForbidden, "media path leaves the signed folder"))
+
+		return
+	}
+
+	c.Set("mediaFile", file)
+	c.Next()
+}
+
+func (server *FNRadioServer) serveMedia(c *gin.Context) {
+	file := c.GetString("mediaFile")
+	if strings.HasSuffix(file, "/") {
+		c.Status(404)
+		return
+	}
+
+	// Only requests that made it past the signature check count as the media being used
+	server.touchMedia(strings.SplitN(strings.TrimPrefix(path.Clean("/"+file), "/"), "/", 2)[0])
+
+	c.FileFromFS(file, http.Dir("media"))
+}
+
+// touchMedia records that folder is being played, which keeps it from being evicted and keeps the
+// stream station writing to it running.
+func (server *FNRadioServer) touchMedia(folder string) {
+	if strings.HasPrefix(folder, "STR_") {
+		if streamStation := server.StreamStations.GetByFolder(folder); streamStation != nil {
+			streamStation.LastRequest = time.Now()
+		}
+
+		return
+	}
+
+	if _, err := os.Stat("media/" + folder); err == nil {
+		server.Cache.Touch(folder)
+	}
+}
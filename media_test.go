@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createMediaFolder(t *testing.T, folder string, contents string) {
+	t.Helper()
+
+	err := os.MkdirAll("media/"+folder, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll("media/" + folder)
+	})
+
+	err = os.WriteFile("media/"+folder+"/output.m3u8", []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignedMediaStaysInFolder(t *testing.T) {
+	server := newTestServer(t)
+
+	signed := "YT_" + generateID()
+	other := "STR_" + generateID()
+
+	createMediaFolder(t, signed, "signed")
+	createMediaFolder(t, other, "other")
+
+	base := server.mediaURL(signed)
+
+	response := server.request(t, nil, "GET", base+"/output.m3u8", nil)
+	expectStatus(t, response, 200, "")
+
+	if response.Body.String() != "signed" {
+		t.Errorf("unexpected body %q", response.Body)
+	}
+
+	for _, escape := range []string{
+		"/../" + other + "/output.m3u8",
+		"/%2e%2e/" + other + "/output.m3u8",
+		"/sub/../../" + other + "/output.m3u8",
+	} {
+		response := server.request(t, nil, "GET", base+escape, nil)
+
+		if response.Code == 200 || strings.Contains(response.Body.String(), "other") {
+			t.Errorf("%s escaped the signed folder: %d %q", escape, response.Code, response.Body)
+		}
+	}
+
+	// Paths that only look like they leave the folder are fine
+	response = server.request(t, nil, "GET", base+"/sub/../output.m3u8", nil)
+	expectStatus(t, response, 200, "")
+}
+
+func TestUnsignedMediaRequiresOpenAccess(t *testing.T) {
+	server := newTestServer(t)
+
+	folder := "YT_" + generateID()
+	createMediaFolder(t, folder, "media")
+
+	expectStatus(t, server.request(t, nil, "GET", "/media/"+folder+"/output.m3u8", nil), 403, CodeForbidden)
+
+	server.MediaOpenAccess = true
+
+	expectStatus(t, server.request(t, nil, "GET", "/media/"+folder+"/output.m3u8", nil), 200, "")
+}
+
+func TestOnlyValidMediaRequestsTouchFolders(t *testing.T) {
+	server := newTestServer(t)
+
+	folder := "YT_" + generateID()
+	createMediaFolder(t, folder, "media")
+
+	forged := "/media/s/" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "/forged/" + folder + "/output.m3u8"
+
+	expectStatus(t, server.request(t, nil, "GET", "/media/"+folder+"/output.m3u8", nil), 403, CodeForbidden)
+	expectStatus(t, server.request(t, nil, "GET", forged, nil), 403, CodeForbidden)
+
+	if accessed := server.Cache.LastAccess(folder, time.Time{}); !accessed.IsZero() {
+		t.Fatal("rejected media requests kept the folder from being evicted")
+	}
+
+	expectStatus(t, server.request(t, nil, "GET", server.mediaURL(folder)+"/output.m3u8", nil), 200, "")
+
+	if accessed := server.Cache.LastAccess(folder, time.Time{}); accessed.IsZero() {
+		t.Error("signed media request didn't touch the folder")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kkdai/youtube/v2"
+)
+
+// SourceMetadata describes what a media folder contains. It is captured when the folder is ingested.
+type SourceMetadata struct {
+	Folder    string
+	Title     string
+	Author    string
+	Thumbnail string
+	Duration  time.Duration
+}
+
+func youtubeMetadata(video *youtube.Video) SourceMetadata {
+	metadata := SourceMetadata{
+		Folder:   "YT_" + video.ID,
+		Title:    video.Title,
+		Author:   video.Author,
+		Duration: video.Duration,
+	}
+
+	var largest uint
+
+	for _, thumbnail := range video.Thumbnails {
+		if thumbnail.Width*thumbnail.Height >= largest {
+			largest = thumbnail.Width * thumbnail.Height
+			metadata.Thumbnail = thumbnail.URL
+		}
+	}
+
+	return metadata
+}
+
+// probeTags reads the title and artist tags of a media file, if it has any.
+func probeTags(input string) (string, string) {
+	output, err := exec.Command("ffprobe", "-v", "error", "-protocol_whitelist", "file", "-show_entries", "format_tags=title,artist", "-of", "json", input).Output()
+	if err != nil {
+		return "", ""
+	}
+
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+
+	if json.Unmarshal(output, &probe) != nil {
+		return "", ""
+	}
+
+	return probe.Format.Tags["title"], probe.Format.Tags["artist"]
+}
+
+// probeMetadata builds metadata for a media file from its tags, using fallbackTitle if it isn't tagged.
+func probeMetadata(folder string, input string, fallbackTitle string, duration time.Duration) SourceMetadata {
+	title, author := probeTags(input)
+	if title == "" {
+		title = fallbackTitle
+	}
+
+	return SourceMetadata{
+		Folder:   folder,
+		Title:    title,
+		Author:   author,
+		Duration: duration,
+	}
+}
+
+// saveSourceMetadata stores metadata on a best effort basis, it isn't worth failing an ingest over.
+func (server *FNRadioServer) saveSourceMetadata(metadata SourceMetadata) {
+	err := server.Store.SaveSourceMetadata(metadata)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func nowPlayingEntry(entry StreamQueueEntry, metadata map[string]SourceMetadata) gin.H {
+	result := gin.H{
+		"id":       entry.ID,
+		"source":   entry.Source,
+		"state":    entry.State,
+		"position": entry.Position,
+	}
+
+	if source, ok := metadata[entry.Source]; ok {
+		result["title"] = source.Title
+		result["author"] = source.Author
+		result["thumbnail"] = source.Thumbnail
+		result["duration"] = source.Duration.Seconds()
+	}
+
+	return result
+}
+
+func (server *FNRadioServer) getNowPlaying(c *gin.Context) {
+	if !server.checkStationAccess(c) {
+		return
+	}
+
+	station, err := server.Store.GetUserStation(c.Param("user"), c.Param("station"))
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
+
+		return
+	}
+
+	if station.Type != StationTypeStream {
+		respondError(c, newAPIError(CodeInvalidRequest, "station type must be "+StationTypeStream))
+
+		return
+	}
+
+	entries := make([]StreamQueueEntry, 0)
+	if streamStation := server.StreamStations.GetOrRestore(station); streamStation != nil {
+		entries = streamStation.Queue.List()
+	}
+
+	folders := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		folders = append(folders, entry.Source)
+	}
+
+	metadata, err := server.Store.GetSourceMetadata(folders)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	var current gin.H
+
+	upcoming := make([]gin.H, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.State == QueueElementPlaying {
+			current = nowPlayingEntry(entry, metadata)
+			current["elapsed"] = entry.Elapsed.Seconds()
+
+			continue
+		}
+
+		upcoming = append(upcoming, nowPlayingEntry(entry, metadata))
+	}
+
+	c.JSON(200, gin.H{
+		"current":  current,
+		"upcoming": upcoming,
+	})
+}
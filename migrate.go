@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLockID is the advisory lock held while migrating so two servers can't migrate at once.
+const migrationLockID = 4283917
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		match := migrationFileRegex.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		contents, err := migrationFiles.ReadFile("migrations/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// withMigrationLock runs fn on a single connection while holding the migration lock.
+func (store *PostgresStore) withMigrationLock(fn func(conn *pgx.Conn) error) error {
+	conn, err := store.DB.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+
+	defer conn.Release()
+
+	_, err = conn.Exec(context.Background(), "SELECT pg_advisory_lock($1)", migrationLockID)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID) // nolint:errcheck
+
+	_, err = conn.Exec(context.Background(), "CREATE TABLE IF NOT EXISTS public.schema_migrations (version integer NOT NULL, name text NOT NULL, applied_at timestamp with time zone NOT NULL DEFAULT now(), CONSTRAINT schema_migrations_pkey PRIMARY KEY (version))")
+	if err != nil {
+		return err
+	}
+
+	return fn(conn.Conn())
+}
+
+func appliedMigrations(conn *pgx.Conn) (map[int]bool, error) {
+	rows, err := conn.Query(context.Background(), "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+
+	for rows.Next() {
+		var version int
+
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration up to and including target, or all of them if target is 0.
+func (store *PostgresStore) migrateUp(target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return store.withMigrationLock(func(conn *pgx.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if applied[migration.Version] || (target > 0 && migration.Version > target) {
+				continue
+			}
+
+			migration := migration
+
+			err = conn.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+				_, err := tx.Exec(context.Background(), migration.Up)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(context.Background(), "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateDown rolls back the given number of most recently applied migrations.
+func (store *PostgresStore) migrateDown(steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return store.withMigrationLock(func(conn *pgx.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := migrations[i]
+
+			if !applied[migration.Version] {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %04d_%s can't be rolled back", migration.Version, migration.Name)
+			}
+
+			err = conn.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+				_, err := tx.Exec(context.Background(), migration.Down)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(context.Background(), "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rolling back migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+
+			steps--
+		}
+
+		return nil
+	})
+}
+
+func (store *PostgresStore) printMigrationStatus() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return store.withMigrationLock(func(conn *pgx.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			state := "pending"
+			if applied[migration.Version] {
+				state = "applied"
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, state)
+		}
+
+		return nil
+	})
+}
+
+// runMigrateCommand handles "migrate [up [version] | down [steps] | status]".
+func (store *PostgresStore) runMigrateCommand(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	argument := 0
+
+	if len(args) > 1 {
+		var err error
+
+		argument, err = strconv.Atoi(args[1])
+		if err != nil || argument < 0 {
+			return errors.New("invalid migration argument " + args[1])
+		}
+	}
+
+	switch command {
+	case "up":
+		return store.migrateUp(argument)
+	case "down":
+		if argument == 0 {
+			argument = 1
+		}
+
+		return store.migrateDown(argument)
+	case "status":
+		return store.printMigrationStatus()
+	default:
+		return errors.New("unknown migrate command " + command)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestMigrationsCanBeRolledBack(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("expected migration %d, got %04d_%s", i+1, migration.Version, migration.Name)
+		}
+
+		if migration.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", migration.Version, migration.Name)
+		}
+	}
+}
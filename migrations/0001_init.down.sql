@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.bindings;
+
+DROP TABLE IF EXISTS public.stations;
+
+DROP TABLE IF EXISTS public.users;
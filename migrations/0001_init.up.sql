@@ -25,4 +25,4 @@ CREATE TABLE IF NOT EXISTS public.bindings
     station_user character varying(32) COLLATE pg_catalog."default" NOT NULL,
     station_id text COLLATE pg_catalog."default" NOT NULL,
     CONSTRAINT bindings_pkey PRIMARY KEY (user_id, id)
-) TABLESPACE pg_default;
\ No newline at end of file
+) TABLESPACE pg_default;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS public.ingest_jobs
+(
+    folder text COLLATE pg_catalog."default" NOT NULL,
+    state text COLLATE pg_catalog."default" NOT NULL,
+    progress double precision NOT NULL DEFAULT 0,
+    reason text COLLATE pg_catalog."default",
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT ingest_jobs_pkey PRIMARY KEY (folder)
+) TABLESPACE pg_default;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.source_removals
+(
+    id bigserial NOT NULL,
+    folder text COLLATE pg_catalog."default" NOT NULL,
+    reason text COLLATE pg_catalog."default" NOT NULL,
+    removed_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT source_removals_pkey PRIMARY KEY (id)
+) TABLESPACE pg_default;
@@ -0,0 +1,10 @@
+-- Hashed secrets don't fit in the old column and can't be turned back into plaintext, so the column
+-- is only narrowed again if no user has a hashed secret yet. Otherwise it stays text, which the
+-- previous schema version reads just fine.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM public.users WHERE length(secret) > 32) THEN
+        ALTER TABLE public.users ALTER COLUMN secret TYPE character varying(32);
+    END IF;
+END
+$$;
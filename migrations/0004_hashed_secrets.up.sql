@@ -0,0 +1 @@
+ALTER TABLE public.users ALTER COLUMN secret TYPE text;
@@ -0,0 +1 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS tokens_revoked_at timestamp with time zone;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.ingest_usage;
+
+DROP TABLE IF EXISTS public.user_quotas;
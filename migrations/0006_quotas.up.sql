@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS public.user_quotas
+(
+    user_id character varying(32) COLLATE pg_catalog."default" NOT NULL,
+    max_stations integer,
+    max_bindings integer,
+    max_queue_length integer,
+    max_ingest_minutes_per_day integer,
+    CONSTRAINT user_quotas_pkey PRIMARY KEY (user_id),
+    CONSTRAINT "user" FOREIGN KEY (user_id)
+        REFERENCES public.users (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+) TABLESPACE pg_default;
+
+CREATE TABLE IF NOT EXISTS public.ingest_usage
+(
+    user_id character varying(32) COLLATE pg_catalog."default" NOT NULL,
+    day date NOT NULL,
+    seconds double precision NOT NULL DEFAULT 0,
+    CONSTRAINT ingest_usage_pkey PRIMARY KEY (user_id, day),
+    CONSTRAINT "user" FOREIGN KEY (user_id)
+        REFERENCES public.users (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+) TABLESPACE pg_default;
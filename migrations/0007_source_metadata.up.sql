@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.source_metadata
+(
+    folder text COLLATE pg_catalog."default" NOT NULL,
+    title text COLLATE pg_catalog."default" NOT NULL,
+    author text COLLATE pg_catalog."default",
+    thumbnail text COLLATE pg_catalog."default",
+    duration double precision NOT NULL DEFAULT 0,
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT source_metadata_pkey PRIMARY KEY (folder)
+) TABLESPACE pg_default;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.stream_queues
+(
+    user_id character varying(32) COLLATE pg_catalog."default" NOT NULL,
+    station_id text COLLATE pg_catalog."default" NOT NULL,
+    elements jsonb NOT NULL,
+    playback_offset double precision NOT NULL DEFAULT 0,
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT stream_queues_pkey PRIMARY KEY (user_id, station_id),
+    CONSTRAINT station FOREIGN KEY (user_id, station_id)
+        REFERENCES public.stations (user_id, id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+) TABLESPACE pg_default;
@@ -0,0 +1,2 @@
+ALTER TABLE public.stations DROP COLUMN IF EXISTS shuffle;
+ALTER TABLE public.stations DROP COLUMN IF EXISTS repeat;
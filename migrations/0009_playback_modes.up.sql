@@ -0,0 +1,2 @@
+ALTER TABLE public.stations ADD COLUMN IF NOT EXISTS repeat text COLLATE pg_catalog."default" NOT NULL DEFAULT 'off';
+ALTER TABLE public.stations ADD COLUMN IF NOT EXISTS shuffle boolean NOT NULL DEFAULT false;
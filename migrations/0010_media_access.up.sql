@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS public.media_access
+(
+    folder text COLLATE pg_catalog."default" NOT NULL,
+    accessed_at timestamp with time zone NOT NULL,
+    CONSTRAINT media_access_pkey PRIMARY KEY (folder)
+) TABLESPACE pg_default;
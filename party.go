@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"regexp"
 	"sync"
 )
@@ -25,23 +25,22 @@ type PartyStore struct {
 	mu      sync.Mutex
 }
 
+var (
+	ErrPartyExists   = errors.New("party already exists")
+	ErrPartyNotFound = errors.New("party doesn't exist")
+)
+
 var idRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
 var partyIDRegex = regexp.MustCompile(`^V2:[0-9a-f]{32}$`)
 
-func (clientParty *ClientParty) Validate() bool {
-	if !partyIDRegex.MatchString(clientParty.ID) {
-		return false
-	}
-
-	if !idRegex.MatchString(clientParty.Match) {
-		return false
-	}
+func (clientParty *ClientParty) Validate() error {
+	validation := Validation{}
 
-	if !idRegex.MatchString(clientParty.Session) {
-		return false
-	}
+	validation.Check(partyIDRegex.MatchString(clientParty.ID), "id", "must be a V2 party ID")
+	validation.Check(idRegex.MatchString(clientParty.Match), "match", "must be 32 lowercase hex characters")
+	validation.Check(idRegex.MatchString(clientParty.Session), "session", "must be 32 lowercase hex characters")
 
-	return true
+	return validation.Err()
 }
 
 func (store *PartyStore) RemoveUser(user string) bool {
@@ -73,7 +72,7 @@ func (store *PartyStore) CreateOrJoinParty(user string, clientParty ClientParty)
 	for _, party := range store.parties {
 		if party.ID == clientParty.ID && party.Match == clientParty.Match && party.Session == clientParty.Session {
 			if clientParty.Leader {
-				return nil, fmt.Errorf("party already exists")
+				return nil, ErrPartyExists
 			}
 
 			party.Members = append(party.Members, user)
@@ -83,7 +82,7 @@ func (store *PartyStore) CreateOrJoinParty(user string, clientParty ClientParty)
 	}
 
 	if !clientParty.Leader {
-		return nil, fmt.Errorf("party doesn't exist")
+		return nil, ErrPartyNotFound
 	}
 
 	party := &Party{
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type moveQueueElementPayload struct {
+	Position *int `json:"position"`
+}
+
+// setPlaybackPayload changes the playback modes of a stream station. Missing fields are left as they are.
+type setPlaybackPayload struct {
+	Repeat  *string `json:"repeat"`
+	Shuffle *bool   `json:"shuffle"`
+}
+
+// getQueueStation looks up the requesting user's stream station from the path, resuming it if it
+// has a saved queue. The stream station is nil if it isn't running and has nothing queued.
+func (server *FNRadioServer) getQueueStation(c *gin.Context) (*StreamStation, bool) {
+	user := c.MustGet("user").(User)
+
+	station, err := server.Store.GetUserStation(user.ID, c.Param("station"))
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
+
+		return nil, false
+	}
+
+	if station.Type != StationTypeStream {
+		respondError(c, newAPIError(CodeInvalidRequest, "station type must be "+StationTypeStream))
+
+		return nil, false
+	}
+
+	return server.StreamStations.GetOrRestore(station), true
+}
+
+// queueElementID resolves the :element path param, which is either an element ID or a position.
+func queueElementID(queue *StreamQueue, element string) (string, error) {
+	if len(element) < 32 {
+		position, err := strconv.Atoi(element)
+		if err == nil {
+			return queue.ElementID(position)
+		}
+	}
+
+	return element, nil
+}
+
+func (server *FNRadioServer) getQueue(c *gin.Context) {
+	streamStation, ok := server.getQueueStation(c)
+	if !ok {
+		return
+	}
+
+	entries := make([]StreamQueueEntry, 0)
+	if streamStation != nil {
+		entries = streamStation.Queue.List()
+	}
+
+	c.JSON(200, gin.H{
+		"elements": entries,
+	})
+}
+
+func (server *FNRadioServer) removeFromQueue(c *gin.Context) {
+	streamStation, ok := server.getQueueStation(c)
+	if !ok {
+		return
+	}
+
+	if streamStation == nil {
+		respondError(c, ErrQueueElementNotFound)
+
+		return
+	}
+
+	id, err := queueElementID(&streamStation.Queue, c.Param("element"))
+	if err == nil {
+		err = streamStation.Queue.Remove(id)
+	}
+
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.Status(204)
+}
+
+func (server *FNRadioServer) moveQueueElement(c *gin.Context) {
+	var payload moveQueueElementPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	validation := Validation{}
+	validation.Check(payload.Position != nil, "position", "is required")
+
+	err = validation.Err()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	streamStation, ok := server.getQueueStation(c)
+	if !ok {
+		return
+	}
+
+	if streamStation == nil {
+		respondError(c, ErrQueueElementNotFound)
+
+		return
+	}
+
+	id, err := queueElementID(&streamStation.Queue, c.Param("element"))
+	if err == nil {
+		err = streamStation.Queue.Move(id, *payload.Position)
+	}
+
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"elements": streamStation.Queue.List(),
+	})
+}
+
+func (server *FNRadioServer) skipQueueElement(c *gin.Context) {
+	streamStation, ok := server.getQueueStation(c)
+	if !ok {
+		return
+	}
+
+	if streamStation == nil {
+		respondError(c, ErrQueueElementNotFound)
+
+		return
+	}
+
+	err := streamStation.Queue.Skip()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.Status(204)
+}
+
+func (server *FNRadioServer) setPlayback(c *gin.Context) {
+	var payload setPlaybackPayload
+
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		respondError(c, invalidRequest(err))
+
+		return
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	user := c.MustGet("user").(User)
+
+	station, err := server.Store.GetUserStation(user.ID, c.Param("station"))
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
+
+		return
+	}
+
+	if station.Type != StationTypeStream {
+		respondError(c, newAPIError(CodeInvalidRequest, "station type must be "+StationTypeStream))
+
+		return
+	}
+
+	if payload.Repeat != nil {
+		station.Repeat = *payload.Repeat
+	}
+
+	if payload.Shuffle != nil {
+		station.Shuffle = *payload.Shuffle
+	}
+
+	err = server.Store.UpdateStationPlayback(user.ID, station.ID, station.Repeat, station.Shuffle)
+	if err != nil {
+		respondError(c, orNotFound(err, errStationNotFound))
+
+		return
+	}
+
+	if streamStation := server.StreamStations.Get(station); streamStation != nil {
+		streamStation.Queue.SetPlayback(station.Repeat, station.Shuffle)
+	}
+
+	c.JSON(200, gin.H{
+		"repeat":  station.Repeat,
+		"shuffle": station.Shuffle,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSavedQueueIsVisibleAfterRestart(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	server := newTestServer(t)
+	server.DefaultQuota.MaxQueueLength = 2
+
+	// Without a media folder the restored station doesn't get to start ffmpeg, which this test doesn't need
+	err = os.Remove("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": StationTypeStream}), 204, "")
+
+	err = server.Store.SaveStreamQueue(user.ID, "radio", SavedStreamQueue{
+		Elements: []SavedQueueElement{
+			{ID: generateID(), User: user.ID, Source: "YT_aaaaaaaaaaa"},
+			{ID: generateID(), User: user.ID, Source: "YT_bbbbbbbbbbb"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved elements count towards the quota even though the station isn't running yet
+	source := gin.H{"source": "https://www.youtube.com/watch?v=ccccccccccc"}
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio/queue", source), 403, CodeQuotaExceeded)
+
+	response := server.request(t, user, "GET", "/users/@me/stations/radio/queue", nil)
+	expectStatus(t, response, 200, "")
+
+	var body struct {
+		Elements []StreamQueueEntry `json:"elements"`
+	}
+
+	err = json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Elements) != 2 || body.Elements[1].Source != "YT_bbbbbbbbbbb" {
+		t.Fatalf("saved queue wasn't listed: %+v", body.Elements)
+	}
+
+	expectStatus(t, server.request(t, user, "DELETE", "/users/@me/stations/radio/queue/"+body.Elements[1].ID, nil), 204, "")
+
+	if running := server.StreamStations.List(); len(running) != 1 || running[0].Queue.Len() != 1 {
+		t.Error("removing an element didn't act on the restored queue")
+	}
+}
+
+func TestStationWithoutQueueIsntStarted(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/radio", gin.H{"type": StationTypeStream}), 204, "")
+	expectStatus(t, server.request(t, user, "GET", "/users/@me/stations/radio/queue", nil), 200, "")
+	expectStatus(t, server.request(t, user, "POST", "/users/@me/stations/radio/queue/skip", nil), 404, CodeNotFound)
+
+	if running := server.StreamStations.List(); len(running) != 0 {
+		t.Errorf("listing an empty queue started %d stations", len(running))
+	}
+}
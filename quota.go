@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Quota limits what a single user can do. A limit of 0 means unlimited.
+type Quota struct {
+	MaxStations            int `json:"max_stations"`
+	MaxBindings            int `json:"max_bindings"`
+	MaxQueueLength         int `json:"max_queue_length"`
+	MaxIngestMinutesPerDay int `json:"max_ingest_minutes_per_day"`
+}
+
+// QuotaOverrides are per-user exceptions to the default quota. Nil fields use the default.
+type QuotaOverrides struct {
+	MaxStations            *int `json:"max_stations"`
+	MaxBindings            *int `json:"max_bindings"`
+	MaxQueueLength         *int `json:"max_queue_length"`
+	MaxIngestMinutesPerDay *int `json:"max_ingest_minutes_per_day"`
+}
+
+var (
+	ErrStationQuotaExceeded = errors.New("station quota exceeded")
+	ErrBindingQuotaExceeded = errors.New("binding quota exceeded")
+	ErrQueueQuotaExceeded   = errors.New("queue length quota exceeded")
+	ErrIngestQuotaExceeded  = errors.New("daily ingest quota exceeded")
+)
+
+func quotaFromEnv(env string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(env))
+	if err != nil || value < 0 {
+		return fallback
+	}
+
+	return value
+}
+
+func loadDefaultQuota() Quota {
+	return Quota{
+		MaxStations:            quotaFromEnv("QUOTA_MAX_STATIONS", 50),
+		MaxBindings:            quotaFromEnv("QUOTA_MAX_BINDINGS", 100),
+		MaxQueueLength:         quotaFromEnv("QUOTA_MAX_QUEUE_LENGTH", 200),
+		MaxIngestMinutesPerDay: quotaFromEnv("QUOTA_MAX_INGEST_MINUTES_PER_DAY", 600),
+	}
+}
+
+func (overrides QuotaOverrides) apply(quota Quota) Quota {
+	if overrides.MaxStations != nil {
+		quota.MaxStations = *overrides.MaxStations
+	}
+
+	if overrides.MaxBindings != nil {
+		quota.MaxBindings = *overrides.MaxBindings
+	}
+
+	if overrides.MaxQueueLength != nil {
+		quota.MaxQueueLength = *overrides.MaxQueueLength
+	}
+
+	if overrides.MaxIngestMinutesPerDay != nil {
+		quota.MaxIngestMinutesPerDay = *overrides.MaxIngestMinutesPerDay
+	}
+
+	return quota
+}
+
+func exceeds(limit int, value int) bool {
+	return limit > 0 && value > limit
+}
+
+func usageDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+func (server *FNRadioServer) getQuota(user string) (Quota, error) {
+	overrides, err := server.Store.GetQuotaOverrides(user)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	return overrides.apply(server.DefaultQuota), nil
+}
+
+func (server *FNRadioServer) checkStationQuota(user string) error {
+	quota, err := server.getQuota(user)
+	if err != nil {
+		return err
+	}
+
+	stations, err := server.Store.GetUserStations(user)
+	if err != nil {
+		return err
+	}
+
+	if exceeds(quota.MaxStations, len(stations)+1) {
+		return ErrStationQuotaExceeded
+	}
+
+	return nil
+}
+
+func (server *FNRadioServer) checkBindingQuota(user string) error {
+	quota, err := server.getQuota(user)
+	if err != nil {
+		return err
+	}
+
+	bindings, err := server.Store.GetUserBindings(user)
+	if err != nil {
+		return err
+	}
+
+	if exceeds(quota.MaxBindings, len(bindings)+1) {
+		return ErrBindingQuotaExceeded
+	}
+
+	return nil
+}
+
+func (server *FNRadioServer) checkQueueQuota(user string, length int) error {
+	quota, err := server.getQuota(user)
+	if err != nil {
+		return err
+	}
+
+	if exceeds(quota.MaxQueueLength, length) {
+		return ErrQueueQuotaExceeded
+	}
+
+	return nil
+}
+
+// chargeIngest counts duration towards the user's daily ingest quota, or fails if it would go over.
+func (server *FNRadioServer) chargeIngest(user string, duration time.Duration) error {
+	quota, err := server.getQuota(user)
+	if err != nil {
+		return err
+	}
+
+	return server.Store.AddIngestUsage(user, usageDay(), duration, time.Duration(quota.MaxIngestMinutesPerDay)*time.Minute)
+}
+
+func (server *FNRadioServer) getQuotaStatus(user string, stations int, bindings int) (map[string]interface{}, error) {
+	quota, err := server.getQuota(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ingested, err := server.Store.GetIngestUsage(user, usageDay())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"limits": quota,
+		"usage": map[string]interface{}{
+			"stations":             stations,
+			"bindings":             bindings,
+			"ingest_minutes_today": ingested.Minutes(),
+		},
+	}, nil
+}
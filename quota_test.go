@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChargeIngestIsAtomic(t *testing.T) {
+	server := newTestServer(t)
+	server.DefaultQuota.MaxIngestMinutesPerDay = 10
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		charged  int
+		rejected int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := server.chargeIngest("user", time.Minute)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case err == nil:
+				charged++
+			case errors.Is(err, ErrIngestQuotaExceeded):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if charged != 10 || rejected != 10 {
+		t.Errorf("expected 10 charged and 10 rejected, got %d and %d", charged, rejected)
+	}
+}
+
+func TestAdminQuotaOverrides(t *testing.T) {
+	server := newTestServer(t)
+	user := server.createTestUser(t)
+
+	expectStatus(t, server.request(t, user, "GET", "/admin/users/"+user.ID+"/quota", nil), 401, CodeUnauthorized)
+
+	response := server.adminRequest(t, "PUT", "/admin/users/"+user.ID+"/quota", gin.H{"max_stations": 1})
+	expectStatus(t, response, 200, "")
+
+	var body struct {
+		Limits Quota `json:"limits"`
+	}
+
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Limits.MaxStations != 1 || body.Limits.MaxBindings != server.DefaultQuota.MaxBindings {
+		t.Errorf("unexpected limits %+v", body.Limits)
+	}
+
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/first", gin.H{"type": StationTypeStream}), 204, "")
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/second", gin.H{"type": StationTypeStream}), 403, CodeQuotaExceeded)
+
+	expectStatus(t, server.adminRequest(t, "PUT", "/admin/users/"+user.ID+"/quota", gin.H{"max_stations": -1}), 400, CodeInvalidRequest)
+	expectStatus(t, server.adminRequest(t, "GET", "/admin/users/missing/quota", nil), 404, CodeNotFound)
+
+	// Clearing the override restores the default
+	expectStatus(t, server.adminRequest(t, "PUT", "/admin/users/"+user.ID+"/quota", gin.H{}), 200, "")
+	expectStatus(t, server.request(t, user, "PUT", "/users/@me/stations/second", gin.H{"type": StationTypeStream}), 204, "")
+}
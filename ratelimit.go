@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter is a token bucket per key. Burst tokens are available up front and refill at Rate per second.
+type RateLimiter struct {
+	Rate      float64
+	Burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+type RateLimits struct {
+	Accounts *RateLimiter
+	Ingest   *RateLimiter
+	Read     *RateLimiter
+	// AuthFailures is taken from per client IP on every failed authentication, once it runs out the
+	// IP can't try to authenticate until it refills.
+	AuthFailures *RateLimiter
+}
+
+// parseRateLimit reads a budget like "5/1h" (5 requests per hour) from the environment. "off" disables the limit.
+func parseRateLimit(env string, fallback string) *RateLimiter {
+	value := os.Getenv(env)
+	if value == "" {
+		value = fallback
+	}
+
+	if value == "off" {
+		return nil
+	}
+
+	count, period, found := strings.Cut(value, "/")
+	if !found {
+		panic("invalid " + env + ": " + value)
+	}
+
+	burst, err := strconv.ParseFloat(count, 64)
+	if err != nil || burst <= 0 {
+		panic("invalid " + env + ": " + value)
+	}
+
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		panic("invalid " + env + ": " + value)
+	}
+
+	return &RateLimiter{
+		Rate:  burst / duration.Seconds(),
+		Burst: burst,
+	}
+}
+
+func loadRateLimits() RateLimits {
+	return RateLimits{
+		Accounts: parseRateLimit("RATE_LIMIT_ACCOUNTS", "5/1h"),
+		Ingest:   parseRateLimit("RATE_LIMIT_INGEST", "30/1m"),
+		Read:     parseRateLimit("RATE_LIMIT_READ", "300/1m"),
+
+		AuthFailures: parseRateLimit("RATE_LIMIT_AUTH_FAILURES", "20/10m"),
+	}
+}
+
+// loadTrustedProxies reads the comma separated addresses or CIDRs of the proxies allowed to set
+// X-Forwarded-For. Without any, the client IP is always the address of the connection.
+func loadTrustedProxies() []string {
+	var proxies []string
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// Allow takes a token from key's bucket. If there isn't one, it returns how long until there will be.
+func (limiter *RateLimiter) Allow(key string) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	bucket := limiter.refill(key, time.Now())
+
+	if bucket.tokens < 1 {
+		return false, limiter.retryAfter(bucket)
+	}
+
+	bucket.tokens--
+
+	return true, 0
+}
+
+// Exhausted is like Allow, but only checks whether there is a token without taking it.
+func (limiter *RateLimiter) Exhausted(key string) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	bucket := limiter.refill(key, time.Now())
+
+	if bucket.tokens < 1 {
+		return true, limiter.retryAfter(bucket)
+	}
+
+	return false, 0
+}
+
+func (limiter *RateLimiter) retryAfter(bucket *tokenBucket) time.Duration {
+	return time.Duration((1 - bucket.tokens) / limiter.Rate * float64(time.Second))
+}
+
+// refill returns key's bucket with the tokens it earned since it was last used, limiter.mu must be held.
+func (limiter *RateLimiter) refill(key string, now time.Time) *tokenBucket {
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*tokenBucket)
+	}
+
+	if now.Sub(limiter.lastPrune) > time.Minute {
+		limiter.prune(now)
+	}
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens:  limiter.Burst,
+			updated: now,
+		}
+
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.Burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*limiter.Rate)
+	bucket.updated = now
+
+	return bucket
+}
+
+// prune forgets buckets that would have refilled completely by now, as they're no different from new ones.
+func (limiter *RateLimiter) prune(now time.Time) {
+	limiter.lastPrune = now
+
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*limiter.Rate >= limiter.Burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// rateLimit limits requests per authenticated user, or per client IP on routes without authentication.
+func (server *FNRadioServer) rateLimit(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if user, ok := c.Get("user"); ok {
+			key = "user:" + user.(User).ID
+		}
+
+		allowed, retryAfter := limiter.Allow(key)
+		if !allowed {
+			respondRateLimited(c, retryAfter, "too many requests")
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func respondRateLimited(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondError(c, newAPIError(CodeRateLimited, message))
+}
+
+// checkAuthFailures rejects the request if its client IP failed to authenticate too often. It runs
+// before any credentials are checked so guessing secrets can't keep the server busy with bcrypt.
+func (server *FNRadioServer) checkAuthFailures(c *gin.Context) bool {
+	limiter := server.RateLimits.AuthFailures
+	if limiter == nil {
+		return true
+	}
+
+	exhausted, retryAfter := limiter.Exhausted("ip:" + c.ClientIP())
+	if exhausted {
+		respondRateLimited(c, retryAfter, "too many failed authentication attempts")
+
+		return false
+	}
+
+	return true
+}
+
+func (server *FNRadioServer) recordAuthFailure(c *gin.Context) {
+	if limiter := server.RateLimits.AuthFailures; limiter != nil {
+		limiter.Allow("ip:" + c.ClientIP())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthFailuresAreLimited(t *testing.T) {
+	server := newTestServer(t)
+	server.RateLimits.AuthFailures = &RateLimiter{Rate: 0.001, Burst: 2}
+
+	user := server.createTestUser(t)
+	wrong := &testUser{ID: user.ID, Secret: "wrong"}
+
+	expectStatus(t, server.request(t, user, "GET", "/users/@me", nil), 200, "")
+	expectStatus(t, server.request(t, wrong, "GET", "/users/@me", nil), 401, CodeUnauthorized)
+	expectStatus(t, server.request(t, wrong, "GET", "/users/@me", nil), 401, CodeUnauthorized)
+
+	// Out of failures, even correct credentials aren't checked anymore
+	expectStatus(t, server.request(t, user, "GET", "/users/@me", nil), 429, CodeRateLimited)
+}
+
+func createUserFrom(server *FNRadioServer, forwardedFor string) int {
+	request := httptest.NewRequest("POST", "/users", nil)
+	request.Header.Set("X-Forwarded-For", forwardedFor)
+
+	recorder := httptest.NewRecorder()
+	server.Router.ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func TestForwardedForNeedsTrustedProxy(t *testing.T) {
+	server := newTestServer(t)
+	server.RateLimits.Accounts = &RateLimiter{Rate: 0.001, Burst: 1}
+	server.setupRouter()
+
+	if code := createUserFrom(server, "203.0.113.1"); code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	if code := createUserFrom(server, "203.0.113.2"); code != 429 {
+		t.Errorf("X-Forwarded-For from an untrusted peer bypassed the limit: %d", code)
+	}
+
+	// httptest requests come from 192.0.2.1
+	server.TrustedProxies = []string{"192.0.2.1"}
+	server.setupRouter()
+
+	if code := createUserFrom(server, "203.0.113.3"); code != 200 {
+		t.Errorf("X-Forwarded-For from a trusted proxy was ignored: %d", code)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	RemovalReasonBroken = "media folder is incomplete"
+)
+
+// playlistsContaining returns the playlist folders that were built from source.
+func playlistsContaining(source string) []string {
+	dir, err := os.ReadDir("media")
+	if err != nil {
+		return nil
+	}
+
+	entry := "file '../" + source + "/master.m3u8'"
+
+	var playlists []string
+
+	for _, file := range dir {
+		if !strings.HasPrefix(file.Name(), "PL_") || file.Name() == source {
+			continue
+		}
+
+		playlist, err := os.ReadFile("media/" + file.Name() + "/playlist.txt")
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(playlist), "\n") {
+			if line == entry {
+				playlists = append(playlists, file.Name())
+				break
+			}
+		}
+	}
+
+	return playlists
+}
+
+// removeSource deletes a media folder along with every playlist built from it, the stations
+// playing any of them and the bindings pointing at those stations. The store changes happen
+// atomically, and the folders are only deleted from disk once they have been committed. Ingests
+// still writing to any of the folders are cancelled.
+func (server *FNRadioServer) removeSource(folder string, reason string) error {
+	folders := append([]string{folder}, playlistsContaining(folder)...)
+	reasons := make(map[string]string, len(folders))
+
+	for _, removed := range folders {
+		reasons[removed] = reason
+		if removed != folder {
+			reasons[removed] = "playlist item " + folder + " was removed: " + reason
+		}
+	}
+
+	err := server.Store.RemoveSources(reasons)
+	if err != nil {
+		return err
+	}
+
+	for _, removed := range folders {
+		server.Ingests.Cancel(removed)
+		_ = os.RemoveAll("media/" + removed)
+		server.Cache.Forget(removed)
+	}
+
+	return nil
+}
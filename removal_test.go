@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// testPostgresStore connects to the database in TEST_DATABASE_URL and migrates it. Tests using it
+// are skipped without one.
+func testPostgresStore(t *testing.T) *PostgresStore {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL isn't set")
+	}
+
+	db, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(db.Close)
+
+	store := &PostgresStore{DB: db}
+
+	err = store.migrateUp(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// removalFixture is a user with one station and one binding per folder.
+type removalFixture struct {
+	user    string
+	folders map[string]string
+}
+
+func newRemovalFixture(t *testing.T, store *PostgresStore, folders ...string) *removalFixture {
+	t.Helper()
+
+	fixture := &removalFixture{
+		user:    generateID(),
+		folders: make(map[string]string, len(folders)),
+	}
+
+	err := store.CreateUser(User{ID: fixture.user, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, _ = store.DB.Exec(context.Background(), "DELETE FROM bindings WHERE user_id = $1", fixture.user)
+		_, _ = store.DB.Exec(context.Background(), "DELETE FROM users WHERE id = $1", fixture.user)
+		_, _ = store.DB.Exec(context.Background(), "DELETE FROM source_removals WHERE folder = ANY($1)", folders)
+	})
+
+	for i, folder := range folders {
+		station := "station" + string(rune('a'+i))
+		fixture.folders[folder] = station
+
+		err = store.CreateStation(Station{
+			UserID: fixture.user,
+			ID:     station,
+			Type:   StationTypeStatic,
+			Source: sql.NullString{String: folder, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.PutBinding(fixture.user, Binding{ID: station, StationUser: fixture.user, StationID: station})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fixture
+}
+
+// assertPresent checks whether the station and binding playing folder still exist.
+func (fixture *removalFixture) assertPresent(t *testing.T, store *PostgresStore, folder string, present bool) {
+	t.Helper()
+
+	id := fixture.folders[folder]
+
+	_, err := store.GetUserStation(fixture.user, id)
+	if present && err != nil {
+		t.Errorf("station playing %s is gone: %v", folder, err)
+	} else if !present && !errors.Is(err, ErrNotFound) {
+		t.Errorf("station playing %s wasn't removed: %v", folder, err)
+	}
+
+	_, err = store.GetUserBinding(fixture.user, id)
+	if present && err != nil {
+		t.Errorf("binding to the station playing %s is gone: %v", folder, err)
+	} else if !present && !errors.Is(err, ErrNotFound) {
+		t.Errorf("binding to the station playing %s wasn't removed: %v", folder, err)
+	}
+}
+
+func removalReasons(t *testing.T, store *PostgresStore, folder string) []string {
+	t.Helper()
+
+	rows, err := store.DB.Query(context.Background(), "SELECT reason FROM source_removals WHERE folder = $1", folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var reasons []string
+
+	for rows.Next() {
+		var reason string
+
+		err = rows.Scan(&reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reasons = append(reasons, reason)
+	}
+
+	return reasons
+}
+
+func TestRemoveSources(t *testing.T) {
+	store := testPostgresStore(t)
+
+	removed := "YT_" + generateID()
+	kept := "YT_" + generateID()
+
+	fixture := newRemovalFixture(t, store, removed, kept)
+
+	err := store.RemoveSources(map[string]string{removed: "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.assertPresent(t, store, removed, false)
+	fixture.assertPresent(t, store, kept, true)
+
+	if reasons := removalReasons(t, store, removed); len(reasons) != 1 || reasons[0] != "gone" {
+		t.Errorf("unexpected removal records %v", reasons)
+	}
+
+	if reasons := removalReasons(t, store, kept); len(reasons) != 0 {
+		t.Errorf("kept folder has removal records %v", reasons)
+	}
+}
+
+func TestRemoveSourcesRollsBack(t *testing.T) {
+	store := testPostgresStore(t)
+
+	folder := "YT_" + generateID()
+
+	fixture := newRemovalFixture(t, store, folder)
+
+	// Postgres refuses NUL bytes in text, so recording the removal fails after the deletes ran
+	err := store.RemoveSources(map[string]string{folder: "broken\x00"})
+	if err == nil {
+		t.Fatal("expected RemoveSources to fail")
+	}
+
+	fixture.assertPresent(t, store, folder, true)
+
+	if reasons := removalReasons(t, store, folder); len(reasons) != 0 {
+		t.Errorf("failed removal left records %v", reasons)
+	}
+}
+
+func TestRemoveSourceCascadesToPlaylists(t *testing.T) {
+	store := testPostgresStore(t)
+
+	item := "YT_" + generateID()
+	playlist := "PL_" + generateID()
+	other := "PL_" + generateID()
+
+	for _, folder := range []string{item, playlist, other} {
+		err := os.MkdirAll("media/"+folder, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		folder := folder
+
+		t.Cleanup(func() {
+			_ = os.RemoveAll("media/" + folder)
+		})
+	}
+
+	err := os.WriteFile("media/"+playlist+"/playlist.txt", []byte("file '../"+item+"/master.m3u8'"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile("media/"+other+"/playlist.txt", []byte("file '../YT_other/master.m3u8'"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := newRemovalFixture(t, store, item, playlist, other)
+
+	server := &FNRadioServer{Store: store}
+
+	err = server.removeSource(item, "video was deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.assertPresent(t, store, item, false)
+	fixture.assertPresent(t, store, playlist, false)
+	fixture.assertPresent(t, store, other, true)
+
+	for _, folder := range []string{item, playlist} {
+		if _, err := os.Stat("media/" + folder); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't deleted from disk", folder)
+		}
+	}
+
+	if _, err := os.Stat("media/" + other); err != nil {
+		t.Errorf("unrelated playlist was deleted from disk: %v", err)
+	}
+
+	if reasons := removalReasons(t, store, playlist); len(reasons) != 1 || reasons[0] != "playlist item "+item+" was removed: video was deleted" {
+		t.Errorf("unexpected playlist removal records %v", reasons)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+type TranscodeTask struct {
+	User     string
+	Folder   string
+	Priority bool
+}
+
+type transcodeWaiter struct {
+	task  TranscodeTask
+	ready chan struct{}
+}
+
+// TranscodeScheduler limits how many ffmpeg processes run at once. Waiting tasks are handed out
+// round-robin between users so one big playlist can't starve everybody else, while priority tasks
+// (things a stream station needs right now) skip the line.
+type TranscodeScheduler struct {
+	Limit    int
+	running  int
+	priority []*transcodeWaiter
+	waiting  map[string][]*transcodeWaiter
+	users    []string
+	mu       sync.Mutex
+}
+
+func transcodeConcurrency() int {
+	limit, err := strconv.Atoi(os.Getenv("TRANSCODE_CONCURRENCY"))
+	if err != nil || limit < 1 {
+		return runtime.NumCPU()
+	}
+
+	return limit
+}
+
+// Acquire blocks until the task may run or ctx is cancelled. The returned function must be called once the task is done.
+func (scheduler *TranscodeScheduler) Acquire(ctx context.Context, task TranscodeTask) (func(), error) {
+	scheduler.mu.Lock()
+
+	if scheduler.running < scheduler.limit() && len(scheduler.priority) == 0 && len(scheduler.users) == 0 {
+		scheduler.running++
+		scheduler.mu.Unlock()
+
+		return scheduler.releaser(), nil
+	}
+
+	waiter := &transcodeWaiter{
+		task:  task,
+		ready: make(chan struct{}),
+	}
+
+	scheduler.enqueue(waiter)
+	scheduler.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return scheduler.releaser(), nil
+	case <-ctx.Done():
+		scheduler.mu.Lock()
+		removed := scheduler.remove(waiter)
+		scheduler.mu.Unlock()
+
+		if !removed {
+			// The slot was handed to us right as we were cancelled, give it back
+			<-waiter.ready
+			scheduler.releaser()()
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// Promote moves a waiting task for the given folder to the front of the line.
+func (scheduler *TranscodeScheduler) Promote(folder string) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	for _, user := range scheduler.users {
+		for _, waiter := range scheduler.waiting[user] {
+			if waiter.task.Folder == folder {
+				scheduler.remove(waiter)
+				waiter.task.Priority = true
+				scheduler.enqueue(waiter)
+
+				return
+			}
+		}
+	}
+}
+
+func (scheduler *TranscodeScheduler) limit() int {
+	if scheduler.Limit < 1 {
+		return 1
+	}
+
+	return scheduler.Limit
+}
+
+func (scheduler *TranscodeScheduler) releaser() func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			scheduler.mu.Lock()
+			defer scheduler.mu.Unlock()
+
+			scheduler.running--
+			scheduler.dispatch()
+		})
+	}
+}
+
+func (scheduler *TranscodeScheduler) enqueue(waiter *transcodeWaiter) {
+	if waiter.task.Priority {
+		scheduler.priority = append(scheduler.priority, waiter)
+		return
+	}
+
+	if scheduler.waiting == nil {
+		scheduler.waiting = make(map[string][]*transcodeWaiter)
+	}
+
+	if len(scheduler.waiting[waiter.task.User]) == 0 {
+		scheduler.users = append(scheduler.users, waiter.task.User)
+	}
+
+	scheduler.waiting[waiter.task.User] = append(scheduler.waiting[waiter.task.User], waiter)
+}
+
+func (scheduler *TranscodeScheduler) remove(waiter *transcodeWaiter) bool {
+	for i, w := range scheduler.priority {
+		if w == waiter {
+			scheduler.priority = append(scheduler.priority[:i], scheduler.priority[i+1:]...)
+			return true
+		}
+	}
+
+	queue := scheduler.waiting[waiter.task.User]
+
+	for i, w := range queue {
+		if w == waiter {
+			scheduler.waiting[waiter.task.User] = append(queue[:i], queue[i+1:]...)
+
+			if len(scheduler.waiting[waiter.task.User]) == 0 {
+				scheduler.removeUser(waiter.task.User)
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (scheduler *TranscodeScheduler) removeUser(user string) {
+	delete(scheduler.waiting, user)
+
+	for i, u := range scheduler.users {
+		if u == user {
+			scheduler.users = append(scheduler.users[:i], scheduler.users[i+1:]...)
+			return
+		}
+	}
+}
+
+func (scheduler *TranscodeScheduler) next() *transcodeWaiter {
+	if len(scheduler.priority) > 0 {
+		waiter := scheduler.priority[0]
+		scheduler.priority = scheduler.priority[1:]
+
+		return waiter
+	}
+
+	if len(scheduler.users) == 0 {
+		return nil
+	}
+
+	user := scheduler.users[0]
+	waiter := scheduler.waiting[user][0]
+	scheduler.waiting[user] = scheduler.waiting[user][1:]
+
+	scheduler.users = scheduler.users[1:]
+
+	if len(scheduler.waiting[user]) > 0 {
+		// Send the user to the back of the line so others get a turn
+		scheduler.users = append(scheduler.users, user)
+	} else {
+		delete(scheduler.waiting, user)
+	}
+
+	return waiter
+}
+
+func (scheduler *TranscodeScheduler) dispatch() {
+	for scheduler.running < scheduler.limit() {
+		waiter := scheduler.next()
+		if waiter == nil {
+			return
+		}
+
+		scheduler.running++
+		close(waiter.ready)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummySecretHash is compared against when a user doesn't exist, so failed logins take as long either way.
+var dummySecretHash, _ = bcrypt.GenerateFromPassword([]byte(generateID()), bcrypt.DefaultCost)
+
+func hashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func isHashedSecret(stored string) bool {
+	return strings.HasPrefix(stored, "$2")
+}
+
+// verifySecret checks secret against what's stored for the user. Secrets created before they were
+// hashed are still stored as plain text; upgrade is true when such a secret matched and should be
+// replaced by its hash.
+func verifySecret(stored string, secret string) (ok bool, upgrade bool) {
+	if isHashedSecret(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(secret)) == nil, false
+	}
+
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(secret)) == 1
+
+	return ok, ok
+}
+
+// authenticate returns the user if the secret is theirs.
+func (server *FNRadioServer) authenticate(id string, secret string) (*User, bool) {
+	user, err := server.Store.GetUser(id)
+	if err != nil {
+		_ = bcrypt.CompareHashAndPassword(dummySecretHash, []byte(secret))
+		return nil, false
+	}
+
+	ok, upgrade := verifySecret(user.Secret, secret)
+	if !ok {
+		return nil, false
+	}
+
+	if upgrade {
+		hash, err := hashSecret(secret)
+		if err == nil && server.Store.SetUserSecret(user.ID, hash) == nil {
+			user.Secret = hash
+		}
+	}
+
+	return user, true
+}
+
+func (server *FNRadioServer) rotateSecret(c *gin.Context) {
+	if !requireBasicAuth(c, "secrets can only be rotated with basic authentication") {
+		return
+	}
+
+	user := c.MustGet("user").(User)
+
+	secret := generateID()
+
+	hash, err := hashSecret(secret)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	err = server.Store.SetUserSecret(user.ID, hash)
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	// Tokens handed out for the old secret shouldn't outlive it
+	_ = server.Store.RevokeUserTokens(user.ID, time.Now())
+
+	c.JSON(200, gin.H{
+		"id":     user.ID,
+		"secret": secret,
+	})
+}
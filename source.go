@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+)
+
+// SourceProvider turns a user supplied source into media folders under media/.
+type SourceProvider interface {
+	// Match reports whether the provider knows how to handle the source.
+	Match(source *url.URL) bool
+	// Resolve returns the media folders the source is made of, in playback order.
+	Resolve(source *url.URL) ([]string, error)
+	// Fetch starts downloading and transcoding a folder returned by Resolve that doesn't exist yet on behalf of user.
+	Fetch(user string, folder string) error
+}
+
+type SourceRegistry struct {
+	providers []SourceProvider
+	ingests   *IngestRegistry
+	cache     *MediaCache
+}
+
+var (
+	ErrInvalidSource   = errors.New("invalid source")
+	ErrNoPlaylistItems = errors.New("no playlist items found")
+)
+
+func (registry *SourceRegistry) Register(provider SourceProvider) {
+	registry.providers = append(registry.providers, provider)
+}
+
+func (registry *SourceRegistry) Find(source string) (SourceProvider, *url.URL, error) {
+	parsed, err := url.Parse(source)
+	if err != nil {
+		return nil, nil, ErrInvalidSource
+	}
+
+	for _, provider := range registry.providers {
+		if provider.Match(parsed) {
+			return provider, parsed, nil
+		}
+	}
+
+	return nil, nil, ErrInvalidSource
+}
+
+func (registry *SourceRegistry) Resolve(user string, source string) ([]string, error) {
+	provider, parsed, err := registry.Find(source)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := provider.Resolve(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	var available []string
+
+	for _, folder := range folders {
+		folder := folder
+
+		// Touching first makes a concurrent eviction of the folder back off, and if it's already
+		// underway Start waits for it and fetches the folder again
+		registry.cache.Touch(folder)
+
+		err = registry.ingests.Start(folder, func() error {
+			return provider.Fetch(user, folder)
+		})
+		if err != nil {
+			if len(folders) == 1 {
+				return nil, err
+			}
+
+			// Skip broken items in multi-item sources (e.g. private videos in a playlist)
+			continue
+		}
+
+		available = append(available, folder)
+	}
+
+	if available == nil {
+		return nil, ErrNoPlaylistItems
+	}
+
+	return available, nil
+}
+
+func (server *FNRadioServer) setupSources() {
+	server.Sources.ingests = &server.Ingests
+	server.Sources.cache = &server.Cache
+
+	server.Sources.Register(&YouTubeProvider{server: server})
+	server.Sources.Register(&HTTPProvider{server: server})
+	server.Sources.Register(&UploadProvider{})
+}
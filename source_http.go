@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	MaxHTTPSourceSize = MaxUploadSize
+
+	// pendingDownloadTTL is how long a resolved download waits to be fetched before it's thrown away.
+	pendingDownloadTTL = 10 * time.Minute
+)
+
+var (
+	ErrSourceTooLarge = errors.New("sources larger than 256 MiB aren't supported")
+	ErrBlockedAddress = errors.New("source address isn't allowed")
+)
+
+// HTTPProvider handles plain http(s) links to audio files. The file is downloaded while resolving so
+// the folder can be named after its content and the duration check runs on what is actually transcoded.
+type HTTPProvider struct {
+	server *FNRadioServer
+	// Client is used for downloads, it defaults to a client that refuses to connect to private addresses.
+	Client *http.Client
+	// MaxSize is the largest body that will be downloaded, it defaults to MaxHTTPSourceSize.
+	MaxSize   int64
+	downloads map[string]pendingDownload
+	mu        sync.Mutex
+}
+
+// pendingDownload is a downloaded file waiting for Fetch to transcode it.
+type pendingDownload struct {
+	path    string
+	name    string
+	created time.Time
+}
+
+var safeHTTPClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: checkDialAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// checkDialAddress refuses connections to loopback, private and link-local addresses. It runs after
+// name resolution, so it also covers redirects and DNS names pointing at internal hosts.
+func checkDialAddress(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return ErrBlockedAddress
+	}
+
+	return nil
+}
+
+func (provider *HTTPProvider) Match(source *url.URL) bool {
+	return (source.Scheme == "http" || source.Scheme == "https") && source.Host != ""
+}
+
+func (provider *HTTPProvider) Resolve(source *url.URL) ([]string, error) {
+	provider.prune(time.Now())
+
+	file, hash, err := provider.download(source.String())
+	if err != nil {
+		return nil, err
+	}
+
+	folder := "HTTP_" + hash
+
+	if _, err := os.Stat("media/" + folder); err == nil {
+		_ = os.Remove(file)
+
+		return []string{folder}, nil
+	}
+
+	provider.mu.Lock()
+
+	if provider.downloads == nil {
+		provider.downloads = make(map[string]pendingDownload)
+	}
+
+	if previous, ok := provider.downloads[folder]; ok {
+		_ = os.Remove(previous.path)
+	}
+
+	provider.downloads[folder] = pendingDownload{
+		path:    file,
+		name:    strings.TrimSuffix(path.Base(source.Path), path.Ext(source.Path)),
+		created: time.Now(),
+	}
+
+	provider.mu.Unlock()
+
+	return []string{folder}, nil
+}
+
+func (provider *HTTPProvider) Fetch(user string, folder string) error {
+	provider.mu.Lock()
+	download, ok := provider.downloads[folder]
+	delete(provider.downloads, folder)
+	provider.mu.Unlock()
+
+	if !ok {
+		return ErrInvalidSource
+	}
+
+	server := provider.server
+
+	server.Jobs.Queue(folder)
+
+	duration, err := probeDuration(download.path)
+	if err == nil {
+		err = checkDuration(duration)
+	}
+
+	if err == nil {
+		err = server.chargeIngest(user, duration)
+	}
+
+	if err == nil {
+		err = os.Mkdir("media/"+folder, 0755)
+	}
+
+	if err != nil {
+		_ = os.Remove(download.path)
+
+		server.Jobs.Fail(folder, err)
+
+		return err
+	}
+
+	server.Jobs.SetDuration(folder, duration)
+	server.saveSourceMetadata(probeMetadata(folder, download.path, download.name, duration))
+
+	go server.transcodeUpload(user, folder, download.path)
+
+	return nil
+}
+
+// download stores the body of source in a temporary file and returns its path and content hash.
+func (provider *HTTPProvider) download(source string) (string, string, error) {
+	client := provider.Client
+	if client == nil {
+		client = safeHTTPClient
+	}
+
+	maxSize := provider.MaxSize
+	if maxSize == 0 {
+		maxSize = MaxHTTPSourceSize
+	}
+
+	response, err := client.Get(source)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", "", fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	if response.ContentLength > maxSize {
+		return "", "", ErrSourceTooLarge
+	}
+
+	temp, err := os.CreateTemp("", "fnradio-http-*")
+	if err != nil {
+		return "", "", err
+	}
+
+	defer temp.Close()
+
+	hash := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(temp, hash), io.LimitReader(response.Body, maxSize+1))
+	if err == nil && written > maxSize {
+		err = ErrSourceTooLarge
+	}
+
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return "", "", err
+	}
+
+	return temp.Name(), hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+// prune throws away downloads that were resolved but never fetched, e.g. because the folder
+// showed up in the meantime.
+func (provider *HTTPProvider) prune(now time.Time) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	for folder, download := range provider.downloads {
+		if now.Sub(download.created) > pendingDownloadTTL {
+			_ = os.Remove(download.path)
+
+			delete(provider.downloads, folder)
+		}
+	}
+}
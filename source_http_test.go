@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHTTPSourceServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(body))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func resolveHTTP(t *testing.T, provider *HTTPProvider, source string) (string, error) {
+	t.Helper()
+
+	parsed, err := url.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := provider.Resolve(parsed)
+	if err != nil {
+		return "", err
+	}
+
+	if len(folders) != 1 {
+		t.Fatalf("expected one folder, got %v", folders)
+	}
+
+	return folders[0], nil
+}
+
+func cleanupDownloads(provider *HTTPProvider) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	for _, download := range provider.downloads {
+		_ = os.Remove(download.path)
+	}
+}
+
+func TestHTTPProviderContentAddressed(t *testing.T) {
+	source := newHTTPSourceServer(t, map[string]string{
+		"/a.mp3":      "first",
+		"/mirror.mp3": "first",
+		"/b.mp3":      "second",
+	})
+
+	provider := &HTTPProvider{Client: source.Client()}
+	defer cleanupDownloads(provider)
+
+	a, err := resolveHTTP(t, provider, source.URL+"/a.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirror, err := resolveHTTP(t, provider, source.URL+"/mirror.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := resolveHTTP(t, provider, source.URL+"/b.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a, "HTTP_") || !sourceFolderRegex.MatchString(a) {
+		t.Errorf("unexpected folder %q", a)
+	}
+
+	if a != mirror {
+		t.Errorf("same content resolved to %q and %q", a, mirror)
+	}
+
+	if a == b {
+		t.Errorf("different content resolved to the same folder %q", a)
+	}
+
+	if len(provider.downloads) != 2 {
+		t.Errorf("expected 2 pending downloads, got %d", len(provider.downloads))
+	}
+}
+
+func TestHTTPProviderRejectsLargeBodies(t *testing.T) {
+	source := newHTTPSourceServer(t, map[string]string{
+		"/large.mp3": strings.Repeat("x", 64),
+	})
+
+	provider := &HTTPProvider{Client: source.Client(), MaxSize: 32}
+	defer cleanupDownloads(provider)
+
+	_, err := resolveHTTP(t, provider, source.URL+"/large.mp3")
+	if !errors.Is(err, ErrSourceTooLarge) {
+		t.Errorf("expected ErrSourceTooLarge, got %v", err)
+	}
+
+	if len(provider.downloads) != 0 {
+		t.Errorf("rejected download was kept")
+	}
+}
+
+func TestHTTPProviderRejectsErrorStatus(t *testing.T) {
+	source := newHTTPSourceServer(t, nil)
+
+	provider := &HTTPProvider{Client: source.Client()}
+
+	_, err := resolveHTTP(t, provider, source.URL+"/missing.mp3")
+	if err == nil {
+		t.Error("expected an error for a 404 response")
+	}
+}
+
+func TestHTTPProviderBlocksPrivateAddresses(t *testing.T) {
+	source := newHTTPSourceServer(t, map[string]string{
+		"/a.mp3": "first",
+	})
+
+	provider := &HTTPProvider{}
+
+	_, err := resolveHTTP(t, provider, source.URL+"/a.mp3")
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("expected ErrBlockedAddress, got %v", err)
+	}
+
+	for _, address := range []string{"127.0.0.1:80", "10.0.0.1:80", "192.168.1.1:443", "169.254.169.254:80", "[::1]:80", "[fe80::1]:80"} {
+		if checkDialAddress("tcp", address, nil) == nil {
+			t.Errorf("%s should be blocked", address)
+		}
+	}
+
+	if err := checkDialAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address was blocked: %v", err)
+	}
+}
+
+func TestHTTPProviderPrunesPendingDownloads(t *testing.T) {
+	source := newHTTPSourceServer(t, map[string]string{
+		"/a.mp3": "first",
+	})
+
+	provider := &HTTPProvider{Client: source.Client()}
+	defer cleanupDownloads(provider)
+
+	folder, err := resolveHTTP(t, provider, source.URL+"/a.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := provider.downloads[folder].path
+
+	provider.prune(time.Now().Add(pendingDownloadTTL + time.Second))
+
+	if len(provider.downloads) != 0 {
+		t.Error("stale download wasn't pruned")
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("stale download file wasn't removed")
+	}
+
+	if err := provider.Fetch("user", folder); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("expected ErrInvalidSource for a pruned download, got %v", err)
+	}
+}
+
+func TestHTTPProviderFetchProbesDownload(t *testing.T) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe isn't installed")
+	}
+
+	source := newHTTPSourceServer(t, map[string]string{
+		"/noise.mp3": "this isn't audio",
+	})
+
+	provider := &HTTPProvider{server: &FNRadioServer{}, Client: source.Client()}
+	defer cleanupDownloads(provider)
+
+	folder, err := resolveHTTP(t, provider, source.URL+"/noise.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := provider.downloads[folder].path
+
+	if err := provider.Fetch("user", folder); !errors.Is(err, ErrUnprobeableMedia) {
+		t.Errorf("expected ErrUnprobeableMedia, got %v", err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("download of a failed fetch wasn't removed")
+	}
+
+	if len(provider.downloads) != 0 {
+		t.Error("fetched download wasn't pruned")
+	}
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"errors"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -36,10 +34,12 @@ func getDuration(output string) (int, error) {
 
 func (server *FNRadioServer) createBlurl(station *Station, c *gin.Context) ([]byte, error) {
 	if c.Request.Header.Get("X-API-Root") == "" {
-		return nil, errors.New("invalid api root")
+		return nil, newAPIError(CodeInvalidRequest, "missing X-API-Root header")
 	}
 
 	if strings.EqualFold(station.Type, StationTypeStatic) {
+		server.Cache.Touch(station.Source.String)
+
 		return server.createStaticBlurl(station, c)
 	}
 
@@ -47,7 +47,7 @@ func (server *FNRadioServer) createBlurl(station *Station, c *gin.Context) ([]by
 		return server.createStreamBlurl(station, c)
 	}
 
-	return nil, errors.New("unknown station type")
+	return nil, newAPIError(CodeInvalidRequest, "unknown station type "+station.Type)
 }
 
 func (server *FNRadioServer) createStaticBlurl(station *Station, c *gin.Context) ([]byte, error) {
@@ -66,7 +66,7 @@ func (server *FNRadioServer) createStaticBlurl(station *Station, c *gin.Context)
 		return nil, err
 	}
 
-	mediaRoot := c.Request.Header.Get("X-API-Root") + "/media/" + url.PathEscape(station.Source.String)
+	mediaRoot := c.Request.Header.Get("X-API-Root") + server.mediaURL(station.Source.String)
 
 	return encodeBlurl(&BLURL{
 		Playlists: []Playlist{
@@ -103,7 +103,7 @@ func (server *FNRadioServer) createStreamBlurl(station *Station, c *gin.Context)
 		return nil, err
 	}
 
-	mediaRoot := c.Request.Header.Get("X-API-Root") + "/media/" + url.PathEscape(streamStation.Folder)
+	mediaRoot := c.Request.Header.Get("X-API-Root") + server.mediaURL(streamStation.Folder)
 
 	return encodeBlurl(&BLURL{
 		Playlists: []Playlist{
@@ -123,22 +123,39 @@ func (server *FNRadioServer) createStreamBlurl(station *Station, c *gin.Context)
 	})
 }
 
-func (server *FNRadioServer) cleanupBrokenStations() {
+// cleanupBrokenStations removes every media folder without a playlist and returns their names.
+func (server *FNRadioServer) cleanupBrokenStations() []string {
+	removed := make([]string, 0)
+
 	dir, err := os.ReadDir("media")
 	if err != nil {
-		return
+		return removed
 	}
 
 	for _, file := range dir {
+		if strings.HasPrefix(file.Name(), "STR_") || server.Ingests.InFlight(file.Name()) {
+			// Still being written to
+			continue
+		}
+
+		if _, err := os.Stat("media/" + file.Name()); os.IsNotExist(err) {
+			// Already removed along with a broken playlist item
+			continue
+		}
+
 		if _, err := os.Stat("media/" + file.Name() + "/master.m3u8"); os.IsNotExist(err) {
-			server.nukeSource(file.Name())
+			if server.removeSource(file.Name(), RemovalReasonBroken) == nil {
+				removed = append(removed, file.Name())
+			}
 		}
 	}
+
+	return removed
 }
 
 func (server *FNRadioServer) cleanupStreamStations() {
-	for _, station := range server.StreamStations.Stations {
-		station.Quit <- struct{}{}
+	for _, station := range server.StreamStations.List() {
+		station.Stop()
 	}
 
 	dir, err := os.ReadDir("media")
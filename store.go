@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// Store is everything the server keeps in its database.
+// Methods creating something return ErrConflict if it already exists, and methods updating
+// something return ErrNotFound if it doesn't.
+type Store interface {
+	CreateUser(user User) error
+	GetUser(id string) (*User, error)
+	SetUserSecret(id string, secret string) error
+	// RevokeUserTokens invalidates every token issued to the user up to and including at.
+	RevokeUserTokens(id string, at time.Time) error
+	ListUsers() ([]User, error)
+	// DeleteUser removes a user along with their stations, their bindings and every binding
+	// pointing at their stations.
+	DeleteUser(id string) error
+
+	ListStations() ([]Station, error)
+	GetUserStations(user string) ([]Station, error)
+	GetUserStation(user string, id string) (*Station, error)
+	CreateStation(station Station) error
+	UpdateStationSource(user string, id string, source string) error
+	UpdateStationPlayback(user string, id string, repeat string, shuffle bool) error
+	// DeleteStation removes a station along with every binding pointing at it.
+	DeleteStation(user string, id string) error
+	// SourceReferences counts how many stations and saved stream queues play each media folder.
+	SourceReferences() (map[string]int, error)
+
+	GetUserBindings(user string) ([]Binding, error)
+	GetUserBinding(user string, id string) (*Binding, error)
+	// PutBinding creates the binding or replaces the existing one with the same ID.
+	PutBinding(user string, binding Binding) error
+	DeleteBinding(user string, id string) error
+
+	// RemoveSources atomically deletes the stations playing the given media folders and the
+	// bindings pointing at them, and records why each folder was removed.
+	RemoveSources(reasons map[string]string) error
+
+	GetQuotaOverrides(user string) (QuotaOverrides, error)
+	SetQuotaOverrides(user string, overrides QuotaOverrides) error
+	// GetIngestUsage returns how much media the user ingested on day (formatted as 2006-01-02).
+	GetIngestUsage(user string, day string) (time.Duration, error)
+	// AddIngestUsage adds duration to the user's usage on day, unless that would take it over limit
+	// (0 meaning unlimited), in which case it returns ErrIngestQuotaExceeded. The check and the
+	// addition happen atomically.
+	AddIngestUsage(user string, day string, duration time.Duration, limit time.Duration) error
+
+	// SaveStreamQueue replaces the saved queue of a stream station, or deletes it if the queue is empty.
+	SaveStreamQueue(user string, station string, queue SavedStreamQueue) error
+	GetStreamQueue(user string, station string) (*SavedStreamQueue, error)
+
+	SaveSourceMetadata(metadata SourceMetadata) error
+	// GetSourceMetadata returns the metadata of every given folder that has some.
+	GetSourceMetadata(folders []string) (map[string]SourceMetadata, error)
+
+	// SaveMediaAccess records when media folders were last used. Folders that already have a later
+	// time keep it.
+	SaveMediaAccess(access map[string]time.Time) error
+	GetMediaAccess() (map[string]time.Time, error)
+	DeleteMediaAccess(folders []string) error
+
+	SaveIngestJob(job IngestJob) error
+	GetIngestJob(folder string) (*IngestJob, error)
+	// FailUnfinishedIngestJobs marks every job that isn't ready or failed as failed.
+	FailUnfinishedIngestJobs(reason string) error
+}
+
+func (server *FNRadioServer) setupStore() {
+	server.connectStore(true)
+	server.Jobs.failInterrupted()
+
+	err := server.Cache.Load()
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// connectStore opens the configured store. Only the serving process should migrate it, tools
+// running next to a live deployment pass false.
+func (server *FNRadioServer) connectStore(migrate bool) {
+	switch os.Getenv("STORAGE") {
+	case "memory":
+		server.Store = NewMemoryStore()
+	case "", "postgres":
+		store := connectPostgres()
+
+		if migrate {
+			err := store.migrateUp(0)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		server.Store = store
+	default:
+		panic("unknown STORAGE " + os.Getenv("STORAGE"))
+	}
+
+	server.Jobs.store = server.Store
+	server.StreamStations.persistence = server.Store
+	server.StreamStations.scheduler = &server.Transcodes
+	server.Cache.persistence = server.Store
+}
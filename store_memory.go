@@ -0,0 +1,533 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type stationKey struct {
+	user string
+	id   string
+}
+
+type usageKey struct {
+	user string
+	day  string
+}
+
+type SourceRemoval struct {
+	Folder    string
+	Reason    string
+	RemovedAt time.Time
+}
+
+// MemoryStore is a Store that lives entirely in memory, for tests and small deployments that
+// don't need anything to survive a restart.
+type MemoryStore struct {
+	users    map[string]User
+	stations map[stationKey]Station
+	bindings map[stationKey]Binding
+	jobs     map[string]IngestJob
+	metadata map[string]SourceMetadata
+	access   map[string]time.Time
+	queues   map[stationKey]SavedStreamQueue
+	quotas   map[string]QuotaOverrides
+	usage    map[usageKey]time.Duration
+	removals []SourceRemoval
+	mu       sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]User),
+		stations: make(map[stationKey]Station),
+		bindings: make(map[stationKey]Binding),
+		jobs:     make(map[string]IngestJob),
+		metadata: make(map[string]SourceMetadata),
+		access:   make(map[string]time.Time),
+		queues:   make(map[stationKey]SavedStreamQueue),
+		quotas:   make(map[string]QuotaOverrides),
+		usage:    make(map[usageKey]time.Duration),
+	}
+}
+
+func (store *MemoryStore) CreateUser(user User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[user.ID]; ok {
+		return ErrConflict
+	}
+
+	store.users[user.ID] = user
+
+	return nil
+}
+
+func (store *MemoryStore) GetUser(id string) (*User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &user, nil
+}
+
+func (store *MemoryStore) SetUserSecret(id string, secret string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	user.Secret = secret
+	store.users[id] = user
+
+	return nil
+}
+
+func (store *MemoryStore) RevokeUserTokens(id string, at time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	user.TokensRevokedAt = at
+	store.users[id] = user
+
+	return nil
+}
+
+func (store *MemoryStore) ListUsers() ([]User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	users := make([]User, 0, len(store.users))
+
+	for _, user := range store.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+func (store *MemoryStore) DeleteUser(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(store.users, id)
+	delete(store.quotas, id)
+
+	for key := range store.stations {
+		if key.user == id {
+			delete(store.stations, key)
+			delete(store.queues, key)
+		}
+	}
+
+	for key, binding := range store.bindings {
+		if key.user == id || binding.StationUser == id {
+			delete(store.bindings, key)
+		}
+	}
+
+	for key := range store.usage {
+		if key.user == id {
+			delete(store.usage, key)
+		}
+	}
+
+	return nil
+}
+
+func (store *MemoryStore) ListStations() ([]Station, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stations := make([]Station, 0, len(store.stations))
+
+	for _, station := range store.stations {
+		stations = append(stations, station)
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		if stations[i].UserID != stations[j].UserID {
+			return stations[i].UserID < stations[j].UserID
+		}
+
+		return stations[i].ID < stations[j].ID
+	})
+
+	return stations, nil
+}
+
+func (store *MemoryStore) GetUserStations(user string) ([]Station, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stations := make([]Station, 0)
+
+	for key, station := range store.stations {
+		if key.user == user {
+			stations = append(stations, station)
+		}
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].ID < stations[j].ID
+	})
+
+	return stations, nil
+}
+
+func (store *MemoryStore) GetUserStation(user string, id string) (*Station, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	station, ok := store.stations[stationKey{user, id}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &station, nil
+}
+
+func (store *MemoryStore) CreateStation(station Station) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := stationKey{station.UserID, station.ID}
+
+	if _, ok := store.stations[key]; ok {
+		return ErrConflict
+	}
+
+	store.stations[key] = station
+
+	return nil
+}
+
+func (store *MemoryStore) UpdateStationSource(user string, id string, source string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := stationKey{user, id}
+
+	station, ok := store.stations[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	station.Source.String = source
+	station.Source.Valid = true
+	store.stations[key] = station
+
+	return nil
+}
+
+func (store *MemoryStore) UpdateStationPlayback(user string, id string, repeat string, shuffle bool) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := stationKey{user, id}
+
+	station, ok := store.stations[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	station.Repeat = repeat
+	station.Shuffle = shuffle
+	store.stations[key] = station
+
+	return nil
+}
+
+func (store *MemoryStore) deleteBindingsTo(user string, id string) {
+	for key, binding := range store.bindings {
+		if binding.StationUser == user && binding.StationID == id {
+			delete(store.bindings, key)
+		}
+	}
+}
+
+func (store *MemoryStore) DeleteStation(user string, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.stations, stationKey{user, id})
+	delete(store.queues, stationKey{user, id})
+	store.deleteBindingsTo(user, id)
+
+	return nil
+}
+
+func (store *MemoryStore) SourceReferences() (map[string]int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	references := make(map[string]int)
+
+	for _, station := range store.stations {
+		if station.Source.Valid {
+			references[station.Source.String]++
+		}
+	}
+
+	for _, queue := range store.queues {
+		for _, el := range queue.Elements {
+			references[el.Source]++
+		}
+	}
+
+	return references, nil
+}
+
+func (store *MemoryStore) GetUserBindings(user string) ([]Binding, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bindings := make([]Binding, 0)
+
+	for key, binding := range store.bindings {
+		if key.user == user {
+			bindings = append(bindings, binding)
+		}
+	}
+
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].ID < bindings[j].ID
+	})
+
+	return bindings, nil
+}
+
+func (store *MemoryStore) GetUserBinding(user string, id string) (*Binding, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	binding, ok := store.bindings[stationKey{user, id}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &binding, nil
+}
+
+func (store *MemoryStore) PutBinding(user string, binding Binding) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.bindings[stationKey{user, binding.ID}] = binding
+
+	return nil
+}
+
+func (store *MemoryStore) DeleteBinding(user string, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.bindings, stationKey{user, id})
+
+	return nil
+}
+
+func (store *MemoryStore) RemoveSources(reasons map[string]string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, station := range store.stations {
+		if _, ok := reasons[station.Source.String]; ok && station.Source.Valid {
+			delete(store.stations, key)
+			store.deleteBindingsTo(key.user, key.id)
+		}
+	}
+
+	for folder, reason := range reasons {
+		store.removals = append(store.removals, SourceRemoval{
+			Folder:    folder,
+			Reason:    reason,
+			RemovedAt: time.Now(),
+		})
+	}
+
+	return nil
+}
+
+func (store *MemoryStore) GetQuotaOverrides(user string) (QuotaOverrides, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.quotas[user], nil
+}
+
+func (store *MemoryStore) SetQuotaOverrides(user string, overrides QuotaOverrides) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.quotas[user] = overrides
+
+	return nil
+}
+
+func (store *MemoryStore) GetIngestUsage(user string, day string) (time.Duration, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.usage[usageKey{user, day}], nil
+}
+
+func (store *MemoryStore) AddIngestUsage(user string, day string, duration time.Duration, limit time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := usageKey{user, day}
+
+	if limit > 0 && store.usage[key]+duration > limit {
+		return ErrIngestQuotaExceeded
+	}
+
+	store.usage[key] += duration
+
+	return nil
+}
+
+func (store *MemoryStore) SaveStreamQueue(user string, station string, queue SavedStreamQueue) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(queue.Elements) == 0 {
+		delete(store.queues, stationKey{user, station})
+	} else {
+		store.queues[stationKey{user, station}] = queue
+	}
+
+	return nil
+}
+
+func (store *MemoryStore) GetStreamQueue(user string, station string) (*SavedStreamQueue, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	queue, ok := store.queues[stationKey{user, station}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &queue, nil
+}
+
+func (store *MemoryStore) SaveSourceMetadata(metadata SourceMetadata) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.metadata[metadata.Folder] = metadata
+
+	return nil
+}
+
+func (store *MemoryStore) GetSourceMetadata(folders []string) (map[string]SourceMetadata, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	metadata := make(map[string]SourceMetadata)
+
+	for _, folder := range folders {
+		if source, ok := store.metadata[folder]; ok {
+			metadata[folder] = source
+		}
+	}
+
+	return metadata, nil
+}
+
+func (store *MemoryStore) SaveMediaAccess(access map[string]time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for folder, accessedAt := range access {
+		if accessedAt.After(store.access[folder]) {
+			store.access[folder] = accessedAt
+		}
+	}
+
+	return nil
+}
+
+func (store *MemoryStore) GetMediaAccess() (map[string]time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	access := make(map[string]time.Time, len(store.access))
+
+	for folder, accessedAt := range store.access {
+		access[folder] = accessedAt
+	}
+
+	return access, nil
+}
+
+func (store *MemoryStore) DeleteMediaAccess(folders []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, folder := range folders {
+		delete(store.access, folder)
+	}
+
+	return nil
+}
+
+func (store *MemoryStore) SaveIngestJob(job IngestJob) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.jobs[job.Folder] = job
+
+	return nil
+}
+
+func (store *MemoryStore) GetIngestJob(folder string) (*IngestJob, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	job, ok := store.jobs[folder]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &job, nil
+}
+
+func (store *MemoryStore) FailUnfinishedIngestJobs(reason string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for folder, job := range store.jobs {
+		if job.State != IngestStateReady && job.State != IngestStateFailed {
+			job.State = IngestStateFailed
+			job.Reason = reason
+			job.UpdatedAt = time.Now()
+			store.jobs[folder] = job
+		}
+	}
+
+	return nil
+}
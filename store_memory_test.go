@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreConflicts(t *testing.T) {
+	store := NewMemoryStore()
+
+	err := store.CreateUser(User{ID: "user", Secret: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CreateUser(User{ID: "user", Secret: "second"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate user, got %v", err)
+	}
+
+	if user, _ := store.GetUser("user"); user.Secret != "first" {
+		t.Errorf("duplicate user overwrote the existing one")
+	}
+
+	station := Station{UserID: "user", ID: "radio", Type: StationTypeStatic, Source: sql.NullString{String: "YT_a", Valid: true}}
+
+	err = store.CreateStation(station)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	station.Source.String = "YT_b"
+
+	if err := store.CreateStation(station); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate station, got %v", err)
+	}
+
+	if existing, _ := store.GetUserStation("user", "radio"); existing.Source.String != "YT_a" {
+		t.Errorf("duplicate station overwrote the existing one")
+	}
+}
+
+func TestMemoryStoreUpdatesMissingRows(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.UpdateStationSource("user", "radio", "YT_a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating the source of a missing station, got %v", err)
+	}
+
+	if err := store.UpdateStationPlayback("user", "radio", RepeatAll, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating the playback of a missing station, got %v", err)
+	}
+
+	if err := store.SetUserSecret("user", "secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound setting the secret of a missing user, got %v", err)
+	}
+}
@@ -1,23 +1,77 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"io"
+	"math/rand"
 	"os"
 	"os/exec"
+	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	QueueElementQueued  = "queued"
+	QueueElementLoading = "loading"
+	QueueElementPlaying = "playing"
+)
+
+const (
+	RepeatOff = "off"
+	RepeatAll = "all"
+	RepeatOne = "one"
+)
+
+var ErrQueueElementNotFound = errors.New("queue element not found")
+var ErrQueuePositionInvalid = errors.New("invalid queue position")
+
+// StreamQueueEntry describes a queue element to clients.
+type StreamQueueEntry struct {
+	ID       string `json:"id"`
+	Source   string `json:"source"`
+	State    string `json:"state"`
+	Position int    `json:"position"`
+	// Elapsed is how much of the element has been played, derived from the audio consumed so far.
+	Elapsed time.Duration `json:"-"`
+}
+
+// SavedStreamQueue is what gets persisted of a stream queue so it survives restarts.
+type SavedStreamQueue struct {
+	Elements []SavedQueueElement
+	// Offset is how far into the first element playback got.
+	Offset time.Duration
+}
+
+type SavedQueueElement struct {
+	ID     string `json:"id"`
+	User   string `json:"user"`
+	Source string `json:"source"`
+}
+
 type StreamQueue struct {
 	elements []*StreamQueueElement
-	mu       sync.Mutex
+	changed  bool
+	repeat   string
+	shuffle  bool
+	// crossfade is how many bytes of PCM two consecutive elements overlap by, 0 means hard cuts
+	crossfade  int
+	fadeFrom   *StreamQueueElement
+	fadeTo     *StreamQueueElement
+	fadeLength int
+	// replay is the next run of replaying, preloaded while replaying plays because repeat is about
+	// to play it again
+	replay    *StreamQueueElement
+	replaying *StreamQueueElement
+	mu        sync.Mutex
 }
 
 func (queue *StreamQueue) Add(el *StreamQueueElement) {
 	queue.mu.Lock()
 
 	queue.elements = append(queue.elements, el)
+	queue.changed = true
 
 	defer queue.mu.Unlock()
 }
@@ -26,9 +80,102 @@ func (queue *StreamQueue) shift() {
 	if len(queue.elements) > 0 {
 		queue.elements[0] = nil
 		queue.elements = queue.elements[1:]
+		queue.changed = true
 	}
 }
 
+func (queue *StreamQueue) SetCrossfade(duration time.Duration) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.crossfade = crossfadeBytes(duration)
+}
+
+func (queue *StreamQueue) SetPlayback(repeat string, shuffle bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.repeat = repeat
+	queue.shuffle = shuffle
+}
+
+// advance moves on from the first element once it's finished or skipped, putting it back in the
+// queue if repeat is on.
+func (queue *StreamQueue) advance(skipped bool) {
+	if len(queue.elements) == 0 {
+		return
+	}
+
+	el := queue.elements[0]
+
+	// Elements that never played anything are broken, repeating them would spin forever
+	if el.Elapsed() == 0 && !skipped {
+		queue.shift()
+		return
+	}
+
+	switch {
+	case queue.repeat == RepeatOne && !skipped:
+		queue.elements[0] = queue.takeReplay(el)
+	case queue.repeat == RepeatOne || queue.repeat == RepeatAll:
+		queue.shift()
+		queue.elements = append(queue.elements, queue.takeReplay(el))
+	default:
+		queue.shift()
+	}
+}
+
+// replayOf returns the next run of el, creating it the first time it's asked for.
+func (queue *StreamQueue) replayOf(el *StreamQueueElement) *StreamQueueElement {
+	if queue.replaying != el {
+		queue.dropReplay()
+
+		queue.replay, queue.replaying = el.replay(), el
+	}
+
+	return queue.replay
+}
+
+// takeReplay hands out the preloaded next run of el, or a fresh one if it wasn't preloaded.
+func (queue *StreamQueue) takeReplay(el *StreamQueueElement) *StreamQueueElement {
+	if queue.replaying != el {
+		return el.replay()
+	}
+
+	replay := queue.replay
+	queue.replay, queue.replaying = nil, nil
+
+	return replay
+}
+
+func (queue *StreamQueue) dropReplay() {
+	if queue.replay != nil {
+		queue.replay.Cancel()
+	}
+
+	queue.replay, queue.replaying = nil, nil
+}
+
+// pickNext moves a random element that hasn't started yet right behind the one that is playing.
+func (queue *StreamQueue) pickNext() {
+	candidates := make([]int, 0, len(queue.elements))
+
+	for i := 1; i < len(queue.elements); i++ {
+		if !queue.elements[i].started {
+			candidates = append(candidates, i)
+		}
+	}
+
+	if len(candidates) < 2 {
+		return
+	}
+
+	i := candidates[rand.Intn(len(candidates))]
+
+	queue.elements[1], queue.elements[i] = queue.elements[i], queue.elements[1]
+	queue.changed = true
+}
+
 func (queue *StreamQueue) GetAudioFrame() ([]byte, bool) {
 	queue.mu.Lock()
 	defer queue.mu.Unlock()
@@ -39,40 +186,316 @@ func (queue *StreamQueue) GetAudioFrame() ([]byte, bool) {
 		return frame, false
 	}
 
-	if !queue.elements[0].started {
-		go queue.elements[0].Start()
+	outgoing := queue.elements[0]
+
+	if !outgoing.started {
+		go outgoing.Start()
+	}
+
+	var incoming *StreamQueueElement
+
+	switch {
+	case queue.repeat == RepeatOne || (queue.repeat == RepeatAll && len(queue.elements) == 1):
+		// The element itself comes next, so its replay is preloaded and faded into like any other
+		incoming = queue.replayOf(outgoing)
+	case len(queue.elements) >= 2:
+		incoming = queue.elements[1]
+	}
+
+	if queue.replay != nil && queue.replay != incoming {
+		// Skipped, or the playback mode changed before the replay came up
+		queue.dropReplay()
+	}
+
+	if queue.fadeFrom != outgoing || queue.fadeTo != incoming {
+		// The queue changed under the fade, or there wasn't one
+		queue.fadeFrom, queue.fadeTo = nil, nil
 	}
 
-	read, err := queue.elements[0].Read(frame)
+	remaining, done := outgoing.Buffered()
 
-	if len(queue.elements) >= 2 {
-		if !queue.elements[1].started && (queue.elements[0].IsNearEnd() || err != nil) {
-			go queue.elements[1].Start()
+	if incoming != nil && queue.fadeFrom == nil && queue.crossfade > 0 && done && remaining <= queue.crossfade {
+		if buffered, _ := incoming.Buffered(); buffered > 0 {
+			queue.fadeFrom, queue.fadeTo, queue.fadeLength = outgoing, incoming, remaining
 		}
+	}
+
+	read, err := outgoing.Read(frame)
 
-		if read < BytesPerTick && errors.Is(err, io.EOF) {
-			_, _ = queue.elements[1].Read(frame[read:])
+	if incoming != nil {
+		lead := 0
+		if queue.crossfade > 0 {
+			lead = queue.crossfade + CrossfadePreload
+		}
+
+		if !incoming.started && (outgoing.IsNearEnd(lead) || err != nil) {
+			if queue.shuffle && incoming != queue.replay {
+				queue.pickNext()
+				incoming = queue.elements[1]
+			}
+
+			go incoming.Start()
+		}
+
+		switch {
+		case queue.fadeFrom == outgoing:
+			fadeIn := make([]byte, BytesPerTick)
+			_, _ = incoming.Read(fadeIn)
+
+			mixCrossfade(frame, fadeIn, queue.fadeLength-remaining, queue.fadeLength)
+		case read < BytesPerTick && errors.Is(err, io.EOF):
+			_, _ = incoming.Read(frame[read:])
 		}
 	}
 
 	if errors.Is(err, io.EOF) {
-		queue.shift()
+		queue.advance(false)
 	}
 
 	return frame, true
 }
 
+func (queue *StreamQueue) Len() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return len(queue.elements)
+}
+
+func (queue *StreamQueue) Sources() []string {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	sources := make([]string, 0, len(queue.elements))
+
+	for _, el := range queue.elements {
+		sources = append(sources, el.source)
+	}
+
+	return sources
+}
+
+func (queue *StreamQueue) List() []StreamQueueEntry {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	entries := make([]StreamQueueEntry, 0, len(queue.elements))
+
+	for i, el := range queue.elements {
+		state := QueueElementQueued
+
+		switch {
+		case i == 0 && el.started:
+			state = QueueElementPlaying
+		case el.started:
+			state = QueueElementLoading
+		}
+
+		entries = append(entries, StreamQueueEntry{
+			ID:       el.id,
+			Source:   el.source,
+			State:    state,
+			Position: i,
+			Elapsed:  el.Elapsed(),
+		})
+	}
+
+	return entries
+}
+
+// find returns the index of the element with the given ID, or -1.
+func (queue *StreamQueue) find(id string) int {
+	for i, el := range queue.elements {
+		if el.id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ElementID returns the ID of the element at position, for clients addressing elements by index.
+func (queue *StreamQueue) ElementID(position int) (string, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if position < 0 || position >= len(queue.elements) {
+		return "", ErrQueueElementNotFound
+	}
+
+	return queue.elements[position].id, nil
+}
+
+// Remove drops an element from the queue and stops its decoder. Removing the element that is
+// playing skips it.
+func (queue *StreamQueue) Remove(id string) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	i := queue.find(id)
+	if i < 0 {
+		return ErrQueueElementNotFound
+	}
+
+	queue.elements[i].Cancel()
+	queue.elements[i] = nil
+	queue.elements = append(queue.elements[:i], queue.elements[i+1:]...)
+	queue.changed = true
+
+	return nil
+}
+
+// Move puts an element at position. The element that is playing can't be moved, and nothing can
+// be moved in front of it.
+func (queue *StreamQueue) Move(id string, position int) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	i := queue.find(id)
+	if i < 0 {
+		return ErrQueueElementNotFound
+	}
+
+	first := 0
+	if queue.elements[0].started {
+		first = 1
+	}
+
+	if i < first || position < first || position >= len(queue.elements) {
+		return ErrQueuePositionInvalid
+	}
+
+	el := queue.elements[i]
+
+	queue.elements = append(queue.elements[:i], queue.elements[i+1:]...)
+	queue.elements = append(queue.elements[:position], append([]*StreamQueueElement{el}, queue.elements[position:]...)...)
+	queue.changed = true
+
+	return nil
+}
+
+// Skip stops the element that is playing and moves on to the next one.
+func (queue *StreamQueue) Skip() error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if len(queue.elements) == 0 {
+		return ErrQueueElementNotFound
+	}
+
+	queue.elements[0].Cancel()
+	queue.advance(true)
+
+	return nil
+}
+
+// Clear cancels and drops every element.
+func (queue *StreamQueue) Clear() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for _, el := range queue.elements {
+		el.Cancel()
+	}
+
+	queue.dropReplay()
+
+	queue.elements = nil
+	queue.changed = true
+}
+
+// TakeChanged reports whether elements were added, removed or reordered since the last call.
+func (queue *StreamQueue) TakeChanged() bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	changed := queue.changed
+	queue.changed = false
+
+	return changed
+}
+
+func (queue *StreamQueue) Snapshot() SavedStreamQueue {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	saved := SavedStreamQueue{
+		Elements: make([]SavedQueueElement, 0, len(queue.elements)),
+	}
+
+	for _, el := range queue.elements {
+		saved.Elements = append(saved.Elements, SavedQueueElement{ID: el.id, User: el.user, Source: el.source})
+	}
+
+	if len(queue.elements) > 0 {
+		saved.Offset = queue.elements[0].Elapsed()
+	}
+
+	return saved
+}
+
+// Restore refills the queue from a saved one, resuming the first element at the saved offset.
+func (queue *StreamQueue) Restore(saved SavedStreamQueue, scheduler *TranscodeScheduler) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for i, savedElement := range saved.Elements {
+		el := newStreamQueueElement(savedElement.User, savedElement.Source, scheduler)
+		el.id = savedElement.ID
+
+		if i == 0 {
+			el.offset = saved.Offset
+			el.consumed = int64(saved.Offset.Seconds()*BytesPerSecond) &^ 3 // Keep it aligned to whole samples
+		}
+
+		queue.elements = append(queue.elements, el)
+	}
+}
+
+func (queue *StreamQueue) CancelAll() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for _, el := range queue.elements {
+		el.Cancel()
+	}
+
+	queue.dropReplay()
+}
+
 type StreamQueueElement struct {
-	source  string
-	data    []byte
-	started bool
-	done    bool
-	mu      sync.Mutex
+	id        string
+	user      string
+	source    string
+	data      []byte
+	consumed  int64
+	offset    time.Duration
+	started   bool
+	done      bool
+	scheduler *TranscodeScheduler
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
 }
 
-func (e *StreamQueueElement) Start() {
+func newStreamQueueElement(user string, source string, scheduler *TranscodeScheduler) *StreamQueueElement {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &StreamQueueElement{
+		id:        generateID(),
+		user:      user,
+		source:    source,
+		data:      make([]byte, 0),
+		scheduler: scheduler,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (e *StreamQueueElement) Start() { // nolint:funlen
 	e.started = true
 	master := "media/" + e.source + "/master.m3u8"
+	promoted := false
 
 	for {
 		// Make sure the media source exists
@@ -88,11 +511,38 @@ func (e *StreamQueueElement) Start() {
 			break
 		}
 
+		if !promoted {
+			// We're about to be played, so the source shouldn't wait behind other people's downloads
+			e.scheduler.Promote(e.source)
+			promoted = true
+		}
+
 		// If we're here, it probably means ffmpeg isn't finished downloading the source, so we'll try again in a sec
-		time.Sleep(time.Second)
+		select {
+		case <-time.After(time.Second):
+		case <-e.ctx.Done():
+			e.done = true
+
+			return
+		}
+	}
+
+	release, err := e.scheduler.Acquire(e.ctx, TranscodeTask{User: e.user, Folder: e.source, Priority: true})
+	if err != nil {
+		e.done = true
+
+		return
+	}
+
+	defer release()
+
+	args := []string{"-i", master, "-f", "s16le", "-ar", "44100", "-ac", "2", "pipe:1"}
+	if e.offset > 0 {
+		// Resuming after a restart
+		args = append([]string{"-ss", strconv.FormatFloat(e.offset.Seconds(), 'f', 3, 64)}, args...)
 	}
 
-	command := exec.Command("ffmpeg", "-i", master, "-f", "s16le", "-ar", "44100", "-ac", "2", "pipe:1")
+	command := exec.CommandContext(e.ctx, "ffmpeg", args...)
 
 	pipe, err := command.StdoutPipe()
 	if err != nil {
@@ -119,6 +569,9 @@ func (e *StreamQueueElement) Start() {
 		e.mu.Unlock()
 
 		if err != nil {
+			// Reap the decoder, whether it finished or was killed by Cancel
+			_ = command.Wait()
+
 			e.done = true
 
 			return
@@ -126,12 +579,26 @@ func (e *StreamQueueElement) Start() {
 	}
 }
 
+// replay returns a fresh copy of the element that plays it again from the start.
+func (e *StreamQueueElement) replay() *StreamQueueElement {
+	el := newStreamQueueElement(e.user, e.source, e.scheduler)
+	el.id = e.id
+
+	return el
+}
+
+// Cancel stops the element's decoder, if it's running, and keeps it from starting.
+func (e *StreamQueueElement) Cancel() {
+	e.cancel()
+}
+
 func (e *StreamQueueElement) Read(b []byte) (n int, err error) {
 	e.mu.Lock()
 
 	n = copy(b, e.data)
 
 	e.data = e.data[n:]
+	e.consumed += int64(n)
 
 	if len(e.data) == 0 && e.done {
 		err = io.EOF
@@ -142,9 +609,25 @@ func (e *StreamQueueElement) Read(b []byte) (n int, err error) {
 	return
 }
 
-func (e *StreamQueueElement) IsNearEnd() bool {
+func (e *StreamQueueElement) Elapsed() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return time.Duration(e.consumed) * time.Second / BytesPerSecond
+}
+
+// Buffered returns how many bytes of decoded audio are waiting to be read and whether decoding is over.
+func (e *StreamQueueElement) Buffered() (int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.data), e.done
+}
+
+// IsNearEnd reports whether less than a tick plus lead bytes of audio are left.
+func (e *StreamQueueElement) IsNearEnd(lead int) bool {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	return len(e.data) < BytesPerTick
+	return len(e.data) < BytesPerTick+lead
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepeatPreloadsReplay(t *testing.T) {
+	for _, repeat := range []string{RepeatOne, RepeatAll} {
+		el := bufferedElement(1000, BytesPerTick*5/2)
+
+		queue := StreamQueue{elements: []*StreamQueueElement{el}}
+		queue.SetPlayback(repeat, false)
+
+		_, _ = queue.GetAudioFrame()
+
+		replay := queue.replay
+		if replay == nil || replay.id != el.id {
+			t.Fatalf("%s: replay of the only element wasn't lined up", repeat)
+		}
+
+		// Stand in for the decoder, which would start now that the element is near its end
+		replay.data = pcm(2000, BytesPerTick*2)
+		replay.started = true
+		replay.done = true
+
+		_, _ = queue.GetAudioFrame()
+		frame, _ := queue.GetAudioFrame()
+
+		if got := sampleAt(frame, BytesPerTick-2); got != 2000 {
+			t.Errorf("%s: expected the replay to follow without a gap, got %d", repeat, got)
+		}
+
+		if queue.Len() != 1 || queue.elements[0] != replay {
+			t.Errorf("%s: queue didn't move on to the preloaded replay", repeat)
+		}
+	}
+}
+
+func TestRepeatCrossfadesIntoReplay(t *testing.T) {
+	// Long enough that the replay isn't started before the test stands in for its decoder
+	el := bufferedElement(1000, BytesPerTick*11/2)
+
+	queue := StreamQueue{elements: []*StreamQueueElement{el}}
+	queue.SetPlayback(RepeatAll, false)
+	queue.SetCrossfade(TickLengthInSeconds * time.Second)
+
+	_, _ = queue.GetAudioFrame()
+
+	queue.replay.data = pcm(2000, BytesPerTick*2)
+	queue.replay.started = true
+	queue.replay.done = true
+
+	for i := 0; i < 5 && queue.fadeFrom == nil; i++ {
+		_, _ = queue.GetAudioFrame()
+	}
+
+	if queue.fadeFrom != el || queue.fadeTo == nil || queue.fadeTo.id != el.id {
+		t.Error("repeating element didn't fade into its replay")
+	}
+}
+
+func TestReplayIsDroppedWhenRepeatIsTurnedOff(t *testing.T) {
+	el := bufferedElement(1000, BytesPerTick*3)
+
+	queue := StreamQueue{elements: []*StreamQueueElement{el}}
+	queue.SetPlayback(RepeatOne, false)
+
+	_, _ = queue.GetAudioFrame()
+
+	replay := queue.replay
+
+	queue.SetPlayback(RepeatOff, false)
+
+	_, _ = queue.GetAudioFrame()
+
+	if queue.replay != nil || replay.ctx.Err() == nil {
+		t.Error("replay outlived repeat being turned off")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -20,10 +21,17 @@ type StreamStation struct {
 }
 
 type StreamStationStore struct {
-	Stations []*StreamStation
-	mu       sync.Mutex
+	Stations    []*StreamStation
+	Crossfade   time.Duration
+	persistence Store
+	scheduler   *TranscodeScheduler
+	mu          sync.Mutex
 }
 
+// QueueSaveInterval is how often the playback offset of a stream station is saved when its queue
+// isn't changing.
+const QueueSaveInterval = 10 * time.Second
+
 func (store *StreamStationStore) Get(station *Station) *StreamStation {
 	store.mu.Lock()
 	defer store.mu.Unlock()
@@ -55,10 +63,22 @@ func (store *StreamStationStore) GetOrCreate(station *Station) *StreamStation {
 		ID:          station.ID,
 		Folder:      "STR_" + generateID(),
 		LastRequest: time.Now(),
-		Quit:        make(chan struct{}),
+		Quit:        make(chan struct{}, 1),
 		Queue:       StreamQueue{},
 	}
 
+	streamStation.Queue.SetPlayback(station.Repeat, station.Shuffle)
+	streamStation.Queue.SetCrossfade(store.Crossfade)
+
+	if store.persistence != nil {
+		saved, err := store.persistence.GetStreamQueue(station.UserID, station.ID)
+		if err == nil {
+			streamStation.Queue.Restore(*saved, store.scheduler)
+		} else if !errors.Is(err, ErrNotFound) {
+			fmt.Println(err)
+		}
+	}
+
 	store.Stations = append(store.Stations, streamStation)
 
 	streamStation.Start()
@@ -66,6 +86,30 @@ func (store *StreamStationStore) GetOrCreate(station *Station) *StreamStation {
 	return streamStation
 }
 
+// GetOrRestore returns the running stream station, resuming it first if it stopped with a queue
+// left, e.g. because the server restarted. It's nil if the station isn't running and has nothing
+// queued.
+func (store *StreamStationStore) GetOrRestore(station *Station) *StreamStation {
+	if streamStation := store.Get(station); streamStation != nil {
+		return streamStation
+	}
+
+	if store.persistence == nil {
+		return nil
+	}
+
+	saved, err := store.persistence.GetStreamQueue(station.UserID, station.ID)
+	if err != nil || len(saved.Elements) == 0 {
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Println(err)
+		}
+
+		return nil
+	}
+
+	return store.GetOrCreate(station)
+}
+
 func (store *StreamStationStore) GetByFolder(folder string) *StreamStation {
 	store.mu.Lock()
 	defer store.mu.Unlock()
@@ -79,6 +123,14 @@ func (store *StreamStationStore) GetByFolder(folder string) *StreamStation {
 	return nil
 }
 
+// List returns a snapshot of the running stations.
+func (store *StreamStationStore) List() []*StreamStation {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return append([]*StreamStation(nil), store.Stations...)
+}
+
 func (store *StreamStationStore) Add(station *StreamStation) {
 	store.mu.Lock()
 
@@ -105,9 +157,28 @@ const TickLengthInSeconds = 2
 const BytesPerSecond = 44100 /* sample rate */ * 2 /* 16-bit */ * 2 /* channels (stereo) */
 const BytesPerTick = BytesPerSecond * TickLengthInSeconds
 
+// saveQueue persists the queue and how far into it playback is. An empty queue deletes the saved one.
+func (station *StreamStation) saveQueue() {
+	if station.store.persistence == nil {
+		return
+	}
+
+	err := station.store.persistence.SaveStreamQueue(station.UserID, station.ID, station.Queue.Snapshot())
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// SaveAll persists the queues of all running stations, e.g. before the server shuts down.
+func (store *StreamStationStore) SaveAll() {
+	for _, station := range store.List() {
+		station.saveQueue()
+	}
+}
+
 func (station *StreamStation) RunTicker(ffmpeg *exec.Cmd, stdin io.WriteCloser) {
 	ticker := time.NewTicker(TickLengthInSeconds * time.Second)
-	station.Quit = make(chan struct{}, 1)
+	lastSave := time.Now()
 
 	for {
 		select {
@@ -116,17 +187,29 @@ func (station *StreamStation) RunTicker(ffmpeg *exec.Cmd, stdin io.WriteCloser)
 
 			_, err := stdin.Write(frame)
 			if err != nil {
-				station.Quit <- struct{}{}
-				break
+				station.Stop()
+				continue
+			}
+
+			if station.Queue.TakeChanged() || time.Since(lastSave) > QueueSaveInterval {
+				station.saveQueue()
+
+				lastSave = time.Now()
 			}
 
 			if !hasMore && time.Until(station.LastRequest.Add(time.Second*8)) < 0 {
-				station.Quit <- struct{}{}
-				break
+				station.Stop()
+				continue
 			}
 		case <-station.Quit:
 			ticker.Stop()
 
+			// Keep what changed since the last periodic save, Discard empties the queue first so a
+			// discarded station deletes its saved queue instead
+			station.saveQueue()
+
+			station.Queue.CancelAll()
+
 			_ = ffmpeg.Process.Kill()
 			_ = os.RemoveAll("media/" + station.Folder)
 
@@ -137,6 +220,21 @@ func (station *StreamStation) RunTicker(ffmpeg *exec.Cmd, stdin io.WriteCloser)
 	}
 }
 
+// Stop asks the ticker to shut the station down. It doesn't wait for it to finish.
+func (station *StreamStation) Stop() {
+	select {
+	case station.Quit <- struct{}{}:
+	default:
+		// A stop is already pending
+	}
+}
+
+// Discard stops the station for good, its queue won't be restored.
+func (station *StreamStation) Discard() {
+	station.Queue.Clear()
+	station.Stop()
+}
+
 func (station *StreamStation) Start() {
 	err := os.Mkdir("media/"+station.Folder, 0777)
 	if err != nil {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AuthMethodBasic  = "basic"
+	AuthMethodBearer = "bearer"
+)
+
+type tokenClaims struct {
+	User      string `json:"sub"`
+	IssuedAt  int64  `json:"iat"` // Unix milliseconds
+	ExpiresAt int64  `json:"exp"` // Unix milliseconds
+}
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// loadSigningKey reads an HMAC key from the environment. Without one a random key is used, which
+// means everything signed with it stops being valid once the server restarts.
+func loadSigningKey(env string) []byte {
+	if key := os.Getenv(env); key != "" {
+		return []byte(key)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+func tokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("TOKEN_TTL"))
+	if err != nil || ttl <= 0 {
+		return time.Hour
+	}
+
+	return ttl
+}
+
+func signToken(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func createToken(key []byte, claims tokenClaims) (string, error) {
+	marshaled, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(marshaled)
+
+	return payload + "." + signToken(key, payload), nil
+}
+
+func parseToken(key []byte, token string) (*tokenClaims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signToken(key, payload))) {
+		return nil, errInvalidToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	var claims tokenClaims
+
+	err = json.Unmarshal(decoded, &claims)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if time.Now().UnixMilli() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// authenticateToken returns the user a bearer token was issued to, as long as it hasn't expired or been revoked.
+func (server *FNRadioServer) authenticateToken(token string) (*User, error) {
+	claims, err := parseToken(server.TokenKey, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := server.Store.GetUser(claims.User)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if !user.TokensRevokedAt.IsZero() && claims.IssuedAt <= user.TokensRevokedAt.UnixMilli() {
+		return nil, ErrTokenRevoked
+	}
+
+	return user, nil
+}
+
+// requireBasicAuth rejects requests made with a bearer token, for actions that a leaked short-lived
+// token shouldn't be able to turn into permanent access to the account.
+func requireBasicAuth(c *gin.Context, message string) bool {
+	if c.GetString("auth") != AuthMethodBasic {
+		respondError(c, newAPIError(CodeForbidden, message))
+
+		return false
+	}
+
+	return true
+}
+
+func (server *FNRadioServer) createUserToken(c *gin.Context) {
+	if !requireBasicAuth(c, "tokens can only be created with basic authentication") {
+		return
+	}
+
+	user := c.MustGet("user").(User)
+	now := time.Now()
+	expires := now.Add(tokenTTL())
+
+	token, err := createToken(server.TokenKey, tokenClaims{
+		User:      user.ID,
+		IssuedAt:  now.UnixMilli(),
+		ExpiresAt: expires.UnixMilli(),
+	})
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"token":      token,
+		"expires_at": expires.UTC(),
+	})
+}
+
+func (server *FNRadioServer) revokeUserTokens(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	err := server.Store.RevokeUserTokens(user.ID, time.Now())
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.Status(204)
+}
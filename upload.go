@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const MaxUploadSize = 256 << 20 // 256 MiB
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+// UploadProvider resolves upload:<hash> sources returned by the upload endpoint.
+type UploadProvider struct{}
+
+func (provider *UploadProvider) Match(source *url.URL) bool {
+	return source.Scheme == "upload"
+}
+
+func (provider *UploadProvider) Resolve(source *url.URL) ([]string, error) {
+	if !idRegex.MatchString(source.Opaque) {
+		return nil, ErrInvalidSource
+	}
+
+	return []string{"UP_" + source.Opaque}, nil
+}
+
+func (provider *UploadProvider) Fetch(string, string) error {
+	// Uploads can't be fetched again, the user has to upload the file again
+	return ErrUploadNotFound
+}
+
+// saveUpload stores the uploaded file in a temporary file and returns its path, content hash and original name.
+func (server *FNRadioServer) saveUpload(c *gin.Context) (string, string, string, error) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	defer file.Close()
+
+	temp, err := os.CreateTemp("", "fnradio-upload-*")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	defer temp.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(temp, hash), file)
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return "", "", "", err
+	}
+
+	return temp.Name(), hex.EncodeToString(hash.Sum(nil)[:16]), header.Filename, nil
+}
+
+func (server *FNRadioServer) transcodeUpload(user string, folder string, path string) {
+	defer os.Remove(path)
+
+	ctx := server.Ingests.Context(folder)
+
+	release, err := server.Transcodes.Acquire(ctx, TranscodeTask{User: user, Folder: folder})
+	if err != nil {
+		server.failIngest(folder, err)
+		return
+	}
+
+	defer release()
+
+	server.Jobs.SetState(folder, IngestStateTranscoding)
+
+	file, err := os.Open(path)
+	if err != nil {
+		server.failIngest(folder, err)
+		return
+	}
+
+	defer file.Close()
+
+	err = transcodeToHLS(ctx, "media/"+folder, file, server.trackProgress(folder))
+	if err != nil {
+		server.failIngest(folder, err)
+		return
+	}
+
+	server.completeIngest(folder)
+}
+
+func (server *FNRadioServer) uploadAudio(c *gin.Context) {
+	if c.Request.ContentLength > MaxUploadSize {
+		respondError(c, newAPIError(CodePayloadTooLarge, "uploads can't be larger than 256 MiB"))
+
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxUploadSize)
+
+	path, id, name, err := server.saveUpload(c)
+	if err != nil {
+		respondError(c, &APIError{Code: CodeInvalidRequest, Message: "missing or unreadable file", Err: err})
+
+		return
+	}
+
+	if !probeHasAudio(path) {
+		_ = os.Remove(path)
+
+		respondError(c, newAPIError(CodeInvalidRequest, "file doesn't contain any audio"))
+
+		return
+	}
+
+	duration, err := probeDuration(path)
+	if err == nil {
+		err = checkDuration(duration)
+	}
+
+	if err != nil {
+		_ = os.Remove(path)
+
+		respondError(c, err)
+
+		return
+	}
+
+	folder := "UP_" + id
+
+	transcoding := false
+
+	user := c.MustGet("user").(User)
+
+	err = server.Ingests.Start(folder, func() error {
+		err := server.chargeIngest(user.ID, duration)
+		if err != nil {
+			return err
+		}
+
+		err = os.Mkdir("media/"+folder, 0755)
+		if err != nil {
+			return err
+		}
+
+		server.Jobs.Queue(folder)
+		server.Jobs.SetDuration(folder, duration)
+		server.saveSourceMetadata(probeMetadata(folder, path, strings.TrimSuffix(name, filepath.Ext(name)), duration))
+
+		transcoding = true
+
+		go server.transcodeUpload(user.ID, folder, path)
+
+		return nil
+	})
+
+	if !transcoding {
+		// Either something went wrong or somebody already uploaded the same file
+		_ = os.Remove(path)
+	}
+
+	if err != nil {
+		respondError(c, err)
+
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"source": "upload:" + id,
+		"folder": folder,
+	})
+}
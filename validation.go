@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxSourceLength is the longest source URL accepted from clients.
+const MaxSourceLength = 2048
+
+// Station and binding IDs are picked by clients, so only allow characters that are safe in paths.
+var resourceIDRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+// FieldError explains why a single field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validation collects every problem with a request so clients can fix them all at once.
+type Validation struct {
+	fields []FieldError
+}
+
+func (validation *Validation) Check(ok bool, field string, message string) {
+	if !ok {
+		validation.fields = append(validation.fields, FieldError{Field: field, Message: message})
+	}
+}
+
+func (validation *Validation) CheckResourceID(id string, field string) {
+	validation.Check(resourceIDRegex.MatchString(id), field, "must be 1 to 64 letters, digits, dashes or underscores")
+}
+
+func (validation *Validation) CheckSource(source string, field string) {
+	if source == "" {
+		validation.Check(false, field, "is required")
+		return
+	}
+
+	if len(source) > MaxSourceLength {
+		validation.Check(false, field, "must be at most 2048 characters")
+		return
+	}
+
+	parsed, err := url.Parse(source)
+	if err != nil {
+		validation.Check(false, field, "must be a valid URL")
+		return
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		validation.Check(parsed.Host != "", field, "must include a host")
+	case "upload":
+		validation.Check(idRegex.MatchString(parsed.Opaque), field, "must be a source returned by the upload endpoint")
+	default:
+		validation.Check(false, field, "must be an http, https or upload URL")
+	}
+}
+
+// Err returns the collected problems as an API error, or nil if there weren't any.
+func (validation *Validation) Err() error {
+	if len(validation.fields) == 0 {
+		return nil
+	}
+
+	return &APIError{Code: CodeInvalidRequest, Message: "request validation failed", Details: validation.fields}
+}
+
+// Validate checks the payload for the station at id. The type is normalized to lower case.
+func (payload *createStationPayload) Validate(id string) error {
+	validation := Validation{}
+
+	validation.CheckResourceID(id, "station")
+
+	payload.Type = strings.ToLower(strings.TrimSpace(payload.Type))
+
+	switch payload.Type {
+	case StationTypeStatic:
+		validation.CheckSource(payload.Source, "source")
+	case StationTypeStream:
+		validation.Check(payload.Source == "", "source", "stream stations don't take a source, add to their queue instead")
+	default:
+		validation.Check(false, "type", "must be "+StationTypeStatic+" or "+StationTypeStream)
+	}
+
+	return validation.Err()
+}
+
+func (payload *bindStationPayload) Validate(id string) error {
+	validation := Validation{}
+
+	validation.CheckResourceID(id, "binding")
+	validation.Check(idRegex.MatchString(payload.StationUser), "station_user", "must be a user ID")
+	validation.CheckResourceID(payload.StationID, "station_id")
+
+	return validation.Err()
+}
+
+func (payload *setPlaybackPayload) Validate() error {
+	validation := Validation{}
+
+	if payload.Repeat != nil {
+		switch *payload.Repeat {
+		case RepeatOff, RepeatAll, RepeatOne:
+		default:
+			validation.Check(false, "repeat", "must be "+RepeatOff+", "+RepeatAll+" or "+RepeatOne)
+		}
+	}
+
+	return validation.Err()
+}
+
+func (payload *addToQueuePayload) Validate() error {
+	validation := Validation{}
+
+	validation.CheckSource(payload.Source, "source")
+
+	return validation.Err()
+}
+
+func (overrides *QuotaOverrides) Validate() error {
+	validation := Validation{}
+
+	limits := []struct {
+		field string
+		limit *int
+	}{
+		{"max_stations", overrides.MaxStations},
+		{"max_bindings", overrides.MaxBindings},
+		{"max_queue_length", overrides.MaxQueueLength},
+		{"max_ingest_minutes_per_day", overrides.MaxIngestMinutesPerDay},
+	}
+
+	for _, limit := range limits {
+		validation.Check(limit.limit == nil || *limit.limit >= 0, limit.field, "must be 0 (unlimited) or more")
+	}
+
+	return validation.Err()
+}
@@ -2,19 +2,23 @@ package main
 
 import (
 	"errors"
-	"io"
 	"net/url"
 	"os"
-	"os/exec"
 	"regexp"
-	"time"
+	"strings"
 
 	"github.com/kkdai/youtube/v2"
 )
 
 var youtubeIDRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]{11}$`)
 
-func pickBestFormat(list youtube.FormatList) *youtube.Format {
+var ErrNoFormats = errors.New("video has no downloadable formats")
+
+func pickBestFormat(list youtube.FormatList) (*youtube.Format, error) {
+	if len(list) == 0 {
+		return nil, ErrNoFormats
+	}
+
 	best := list[0]
 
 	for _, format := range list {
@@ -23,7 +27,7 @@ func pickBestFormat(list youtube.FormatList) *youtube.Format {
 		}
 	}
 
-	return &best
+	return &best, nil
 }
 
 func extractYouTubeID(input string) (string, error) {
@@ -62,81 +66,128 @@ func extractYouTubePlaylistID(input string) (string, error) {
 	return "", errors.New("invalid url")
 }
 
-func (server *FNRadioServer) startYouTubeDownload(id string) error {
+func (server *FNRadioServer) startYouTubeDownload(user string, id string) error {
+	folder := "YT_" + id
+
+	server.Jobs.Queue(folder)
+
 	client := youtube.Client{}
 
+	var format *youtube.Format
+
 	video, err := client.GetVideo(id)
-	if err != nil {
-		return err
+	if err == nil {
+		err = checkDuration(video.Duration)
+	}
+
+	if err == nil {
+		format, err = pickBestFormat(video.Formats)
 	}
 
-	if video.Duration == 0 {
-		return errors.New("live streams aren't supported")
+	if err == nil {
+		err = server.chargeIngest(user, video.Duration)
 	}
 
-	if video.Duration > 1*time.Hour {
-		return errors.New("videos longer than 1 hour aren't supported")
+	if err == nil {
+		err = os.Mkdir("media/"+folder, 0755)
 	}
 
-	stream, _, err := client.GetStream(video, pickBestFormat(video.Formats))
 	if err != nil {
+		server.Jobs.Fail(folder, err)
 		return err
 	}
 
-	go server.downloadYouTubeVideo(id, stream)
+	server.Jobs.SetDuration(folder, video.Duration)
+	server.saveSourceMetadata(youtubeMetadata(video))
+
+	go server.downloadYouTubeVideo(user, video, format)
 
 	return nil
 }
 
-func (server *FNRadioServer) downloadYouTubeVideo(id string, stream io.ReadCloser) {
-	dir := "media/YT_" + id
+func (server *FNRadioServer) downloadYouTubeVideo(user string, video *youtube.Video, format *youtube.Format) {
+	folder := "YT_" + video.ID
+	ctx := server.Ingests.Context(folder)
 
-	command := exec.Command("ffmpeg", "-i", "-", "-vn", "-hls_playlist_type", "vod", "-hls_time", "2", "-hls_segment_type", "fmp4", "-hls_flags", "discont_start", "-c:a", "libfdk_aac", "-b:a", "192k", "-master_pl_name", "master.m3u8", dir+"/output.m3u8")
-
-	pipe, err := command.StdinPipe()
+	release, err := server.Transcodes.Acquire(ctx, TranscodeTask{User: user, Folder: folder})
 	if err != nil {
+		server.failIngest(folder, err)
 		return
 	}
 
-	err = os.Mkdir(dir, 0755)
+	defer release()
+
+	server.Jobs.SetState(folder, IngestStateDownloading)
+
+	client := youtube.Client{}
+
+	stream, _, err := client.GetStreamContext(ctx, video, format)
 	if err != nil {
+		server.failIngest(folder, err)
 		return
 	}
 
-	go func() {
-		_, _ = io.Copy(pipe, stream)
+	defer stream.Close()
 
-		_ = pipe.Close()
-	}()
-
-	err = command.Run()
+	server.Jobs.SetState(folder, IngestStateTranscoding)
 
+	err = transcodeToHLS(ctx, "media/"+folder, stream, server.trackProgress(folder))
 	if err != nil {
-		server.nukeSource("YT_" + id)
+		server.failIngest(folder, err)
 		return
 	}
+
+	server.completeIngest(folder)
+}
+
+type YouTubeProvider struct {
+	server *FNRadioServer
+}
+
+func (provider *YouTubeProvider) Match(source *url.URL) bool {
+	if id, _ := extractYouTubeID(source.String()); id != "" {
+		return true
+	}
+
+	playlist, _ := extractYouTubePlaylistID(source.String())
+
+	return playlist != ""
 }
 
-func (server *FNRadioServer) handleYouTubePlaylist(id string) ([]string, error) {
+func (provider *YouTubeProvider) Resolve(source *url.URL) ([]string, error) {
+	if id, _ := extractYouTubeID(source.String()); id != "" {
+		return []string{"YT_" + id}, nil
+	}
+
+	playlistID, err := extractYouTubePlaylistID(source.String())
+	if err != nil {
+		return nil, err
+	}
+
 	client := youtube.Client{}
 
-	playlist, err := client.GetPlaylist("https://www.youtube.com/playlist?list=" + id)
+	playlist, err := client.GetPlaylist("https://www.youtube.com/playlist?list=" + playlistID)
 	if err != nil {
 		return nil, err
 	}
 
-	var sources []string
+	folders := make([]string, 0, len(playlist.Videos))
 
 	for _, video := range playlist.Videos {
-		source, err := server.handleYouTubeSource(video.ID)
-		if err == nil {
-			sources = append(sources, source...)
-		}
+		folders = append(folders, "YT_"+video.ID)
+	}
+
+	if len(folders) == 0 {
+		return nil, ErrNoPlaylistItems
 	}
 
-	if sources == nil {
-		return nil, errors.New("no playlist items found")
+	return folders, nil
+}
+
+func (provider *YouTubeProvider) Fetch(user string, folder string) error {
+	if !strings.HasPrefix(folder, "YT_") {
+		return ErrInvalidSource
 	}
 
-	return sources, nil
+	return provider.server.startYouTubeDownload(user, strings.TrimPrefix(folder, "YT_"))
 }
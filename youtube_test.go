@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func TestPickBestFormat(t *testing.T) {
+	if _, err := pickBestFormat(nil); !errors.Is(err, ErrNoFormats) {
+		t.Errorf("expected ErrNoFormats for a video without formats, got %v", err)
+	}
+
+	format, err := pickBestFormat(youtube.FormatList{
+		{ItagNo: 1, AudioSampleRate: "44100"},
+		{ItagNo: 2, AudioSampleRate: "48000"},
+		{ItagNo: 3, AudioSampleRate: "22050"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format.ItagNo != 2 {
+		t.Errorf("expected the format with the highest sample rate, got %d", format.ItagNo)
+	}
+}